@@ -0,0 +1,148 @@
+// admin.go
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/jshaughn/outlier/scrape"
+)
+
+// muteRequest is the body of a POST to /api/v1/admin/mutes
+type muteRequest struct {
+	Expression string   `json:"expression"`
+	Matchers   string   `json:"matchers"`
+	Rules      []string `json:"rules"`
+	// Duration of the mute, e.g. 2h
+	Duration string `json:"duration"`
+	Comment  string `json:"comment"`
+}
+
+// registerAdmin registers the admin API handlers, requiring "Authorization: Bearer <token>".
+// POST /api/v1/admin/reset discards the selected TS, they are tracked afresh when next
+// queried. POST /api/v1/admin/rebaseline clears the baseline and rule state of the selected
+// TS, the baseline is re-established from new data points. The TS are selected with the
+// expression and match parameters, as for /api/v1/series, one of which is required.
+// /api/v1/admin/mutes lists (GET) and creates (POST) mutes, which require an expression or
+// matchers, /api/v1/admin/mutes/<id> removes (DELETE) a mute.
+func registerAdmin(mux *http.ServeMux, token string, ep scrape.Scrape) {
+	mux.HandleFunc("/api/v1/admin/reset", authorized(token, func(w http.ResponseWriter, r *http.Request) {
+		handleReset(w, r, ep, false)
+	}))
+	mux.HandleFunc("/api/v1/admin/rebaseline", authorized(token, func(w http.ResponseWriter, r *http.Request) {
+		handleReset(w, r, ep, true)
+	}))
+	mux.HandleFunc("/api/v1/admin/mutes", authorized(token, handleMutes))
+	mux.HandleFunc("/api/v1/admin/mutes/", authorized(token, handleUnmute))
+}
+
+// authorized wraps h, rejecting requests without the bearer token
+func authorized(token string, h http.HandlerFunc) http.HandlerFunc {
+	expected := []byte("Bearer " + token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// handleReset resets, or if rebaseline re-baselines, the selected TS. Their pending and
+// firing rules are resolved.
+func handleReset(w http.ResponseWriter, r *http.Request, ep scrape.Scrape, rebaseline bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method [%s] not allowed, use POST", r.Method))
+		return
+	}
+	selected, err := selectSeries(r, true)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ids := make([]string, 0, len(selected))
+	for _, ser := range selected {
+		k := seriesKey(ser.expression, ser.metric)
+		ids = append(ids, seriesID(k))
+		if !rebaseline {
+			fmt.Printf("Admin reset of TS %s\n", k)
+			send(forget(k, ser, ep))
+			continue
+		}
+
+		fmt.Printf("Admin rebaseline of TS %s\n", k)
+		ser.Lock()
+		events := ser.deliver(ser.data.ResolveAll(int64(model.Now())))
+		ser.data.Clear()
+		ser.history = nil
+		if tracked(k, ser) {
+			ep.SetSeries(ser.expression, ser.metric.String(), ser.stats())
+		}
+		ser.Unlock()
+		send(events)
+	}
+	writeData(w, ids)
+}
+
+func handleMutes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeData(w, mutes.active())
+	case http.MethodPost:
+		var req muteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid mute: %v", err))
+			return
+		}
+		d, err := model.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid mute: duration [%s] must be > 0 (e.g. 30m, 2h, 1d)", req.Duration))
+			return
+		}
+		now := time.Now()
+		m := &mute{
+			Expression: req.Expression,
+			Matchers:   req.Matchers,
+			Rules:      req.Rules,
+			CreatedAt:  now,
+			ExpiresAt:  now.Add(time.Duration(d)),
+			Comment:    req.Comment,
+		}
+		if err = mutes.add(m); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid mute: %v", err))
+			return
+		}
+		fmt.Printf("Admin mute [%s] of rules %v for %s %s until %v\n", m.ID, m.Rules, m.Expression, m.Matchers, m.ExpiresAt.Format(TF))
+		writeData(w, m)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method [%s] not allowed, use GET or POST", r.Method))
+	}
+}
+
+func handleUnmute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method [%s] not allowed, use DELETE", r.Method))
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/admin/mutes/")
+	removed, err := mutes.remove(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !removed {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Unknown mute [%s]", id))
+		return
+	}
+	fmt.Printf("Admin unmute [%s]\n", id)
+	writeData(w, id)
+}
@@ -0,0 +1,172 @@
+// admin_test.go
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/jshaughn/outlier/nelson"
+	"github.com/jshaughn/outlier/scrape"
+)
+
+const testToken = "secret"
+
+// testAdmin returns a mux serving the admin API
+func testAdmin() *http.ServeMux {
+	mux := http.NewServeMux()
+	registerAdmin(mux, testToken, scrape.Scrape{})
+	return mux
+}
+
+// every admin handler requires the token
+func TestAdminUnauthorized(t *testing.T) {
+	mux := testAdmin()
+	for _, url := range []string{"/api/v1/admin/reset", "/api/v1/admin/rebaseline", "/api/v1/admin/mutes", "/api/v1/admin/mutes/x"} {
+		for _, token := range []string{"", "wrong", testToken + "x"} {
+			status, resp := serve(t, mux, http.MethodPost, url, token, "")
+			assertEqual(t, http.StatusUnauthorized, status)
+			assertEqual(t, "Unauthorized", resp.Error)
+		}
+	}
+}
+
+func TestAdminMutes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mutes")
+	assertEqual(t, nil, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mutes.json")
+	defer testMutes(t, path)()
+	mux := testAdmin()
+	url := "/api/v1/admin/mutes"
+
+	status, resp := serve(t, mux, http.MethodPost, url, testToken, `{"duration": "1h"}`)
+	assertEqual(t, http.StatusBadRequest, status)
+	assertEqual(t, "Invalid mute: Expression or matchers must be set", resp.Error)
+	status, _ = serve(t, mux, http.MethodPost, url, testToken, `{"matchers": "a=\"1\"", "duration": "0s"}`)
+	assertEqual(t, http.StatusBadRequest, status)
+	status, _ = serve(t, mux, http.MethodPost, url, testToken, `{"matchers": "a=", "duration": "1h"}`)
+	assertEqual(t, http.StatusBadRequest, status)
+	status, _ = serve(t, mux, http.MethodPut, url, testToken, "")
+	assertEqual(t, http.StatusMethodNotAllowed, status)
+
+	status, resp = serve(t, mux, http.MethodPost, url, testToken, `{"matchers": "a=\"1\"", "rules": ["r1"], "duration": "1h", "comment": "deploy"}`)
+	assertEqual(t, http.StatusOK, status)
+	var added mute
+	assertEqual(t, nil, json.Unmarshal(resp.Data, &added))
+	assertEqual(t, 16, len(added.ID))
+	assertEqual(t, "deploy", added.Comment)
+	assertEqual(t, true, mutes.muted("x", model.Metric{"a": "1"}, "r1"))
+
+	status, resp = serve(t, mux, http.MethodGet, url, testToken, "")
+	assertEqual(t, http.StatusOK, status)
+	var listed []mute
+	assertEqual(t, nil, json.Unmarshal(resp.Data, &listed))
+	assertEqual(t, 1, len(listed))
+	assertEqual(t, added.ID, listed[0].ID)
+
+	// the mutes are reloaded from the file
+	assertEqual(t, nil, mutes.load(path))
+	assertEqual(t, 1, len(mutes.active()))
+	assertEqual(t, added.ID, mutes.active()[0].ID)
+	assertEqual(t, true, mutes.muted("x", model.Metric{"a": "1"}, "r1"))
+
+	status, _ = serve(t, mux, http.MethodGet, url+"/"+added.ID, testToken, "")
+	assertEqual(t, http.StatusMethodNotAllowed, status)
+	status, _ = serve(t, mux, http.MethodDelete, url+"/"+added.ID, testToken, "")
+	assertEqual(t, http.StatusOK, status)
+	status, _ = serve(t, mux, http.MethodDelete, url+"/"+added.ID, testToken, "")
+	assertEqual(t, http.StatusNotFound, status)
+	_, resp = serve(t, mux, http.MethodGet, url, testToken, "")
+	assertEqual(t, "[]", string(resp.Data))
+
+	assertEqual(t, nil, mutes.load(path))
+	assertEqual(t, 0, len(mutes.active()))
+}
+
+// expired mutes are dropped, and not restored
+func TestMuteExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mutes")
+	assertEqual(t, nil, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mutes.json")
+	defer testMutes(t, path)()
+
+	expiring := testMute(t, `a="1"`)
+	kept := testMute(t, `a="2"`)
+	assertEqual(t, true, mutes.muted("x", model.Metric{"a": "1"}, "r1"))
+	expiring.ExpiresAt = time.Now().Add(-time.Second)
+	assertEqual(t, false, mutes.muted("x", model.Metric{"a": "1"}, "r1"))
+	assertEqual(t, 1, len(mutes.active()))
+
+	// a file persisted before the mute expired
+	bytes, err := json.Marshal([]*mute{expiring, kept})
+	assertEqual(t, nil, err)
+	assertEqual(t, nil, ioutil.WriteFile(path, bytes, 0644))
+	assertEqual(t, nil, mutes.load(path))
+	assertEqual(t, 1, len(mutes.active()))
+	assertEqual(t, kept.ID, mutes.active()[0].ID)
+
+	assertEqual(t, nil, ioutil.WriteFile(path, []byte("{"), 0644))
+	assertEqual(t, true, mutes.load(path) != nil)
+	assertEqual(t, nil, mutes.load(filepath.Join(dir, "missing.json")))
+}
+
+// reset discards the selected TS, rebaseline clears them, resolving their firing rules
+func TestAdminReset(t *testing.T) {
+	defer resetTracked()
+	defer testMutes(t, "")()
+	recorded, restore := testSinks()
+	defer restore()
+	mux := testAdmin()
+
+	ts := TSExpression{Expr: "x", SampleSize: 10}
+	assertEqual(t, nil, ts.init(defaultOptions()))
+	m1, m2 := model.Metric{"a": "1"}, model.Metric{"a": "2"}
+	ts.processSampleStream(firingStream(m1, ts.SampleSize), defaultOptions(), scrape.Scrape{})
+	ts.processSampleStream(firingStream(m2, ts.SampleSize), defaultOptions(), scrape.Scrape{})
+	// each TS fires the same rules
+	firing := len(recorded.events) / 2
+	assertEqual(t, true, firing > 0)
+
+	// an empty selector never selects every TS
+	for _, url := range []string{"/api/v1/admin/reset", "/api/v1/admin/rebaseline"} {
+		for _, query := range []string{"", "?match=", "?match={}", "?expression=&match=%20"} {
+			status, resp := serve(t, mux, http.MethodPost, url+query, testToken, "")
+			assertEqual(t, http.StatusBadRequest, status)
+			assertEqual(t, "Expression or match must be set", resp.Error)
+		}
+	}
+	assertEqual(t, 2, ts.tracked())
+	assertEqual(t, 2*firing, len(recorded.events))
+	status, resp := serve(t, mux, http.MethodGet, "/api/v1/admin/reset?expression=x", testToken, "")
+	assertEqual(t, http.StatusMethodNotAllowed, status)
+
+	status, resp = serve(t, mux, http.MethodPost, `/api/v1/admin/reset?match={a="1"}`, testToken, "")
+	assertEqual(t, http.StatusOK, status)
+	assertEqual(t, `["`+seriesID(seriesKey("x", m1))+`"]`, string(resp.Data))
+	_, ok := nelsonMap.Load(seriesKey("x", m1))
+	assertEqual(t, false, ok)
+	assertEqual(t, 3*firing, len(recorded.events))
+	for _, e := range recorded.events[2*firing:] {
+		assertEqual(t, "1", e.Labels["a"])
+		assertEqual(t, nelson.Resolved.String(), e.State)
+	}
+
+	status, _ = serve(t, mux, http.MethodPost, "/api/v1/admin/rebaseline?expression=x", testToken, "")
+	assertEqual(t, http.StatusOK, status)
+	v, ok := nelsonMap.Load(seriesKey("x", m2))
+	assertEqual(t, true, ok)
+	ser := v.(*series)
+	assertEqual(t, ts.SampleSize, ser.data.SamplesUntilReady())
+	assertEqual(t, 0, len(ser.history))
+	assertEqual(t, nelson.Inactive, ser.data.State(recorded.events[0].Rule))
+	assertEqual(t, 4*firing, len(recorded.events))
+	assertEqual(t, "2", recorded.events[3*firing].Labels["a"])
+}
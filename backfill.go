@@ -0,0 +1,92 @@
+// backfill.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	"github.com/jshaughn/outlier/scrape"
+)
+
+// maxRangePoints limits the points per TS in a single range query, Prometheus rejects
+// queries exceeding 11,000 points per TS.
+const maxRangePoints = 10000
+
+// backfillCmd backfills the watched TSExpressions, see backfill
+func backfillCmd(o options) error {
+	watched, err := expressions(o)
+	if err != nil {
+		return err
+	}
+	client, err := api.NewClient(api.Config{Address: o.server})
+	if err != nil {
+		return err
+	}
+	return backfill(context.Background(), watched, o, v1.NewAPI(client))
+}
+
+// backfill evaluates each TSExpression over [o.start, o.end], at o.step or the TSExpression
+// interval, and prints its report. The range is queried in chunks of at most
+// maxRangePoints points.
+func backfill(ctx context.Context, tsExpressions []TSExpression, o options, api v1.API) error {
+	fmt.Printf("Backfill [%v - %v]\n", o.start.Format(TF), o.end.Format(TF))
+
+	ep := scrape.Scrape{}
+	for _, ts := range tsExpressions {
+		// the step defaults to the TS interval
+		step := o.step
+		if step == 0 {
+			step = ts.Interval
+		}
+		r := newReport(ts)
+
+		// chunk the range to respect the per-TS point limit
+		for start := o.start; !start.After(o.end); start = start.Add(maxRangePoints * step) {
+			end := start.Add((maxRangePoints - 1) * step)
+			if end.After(o.end) {
+				end = o.end
+			}
+			rng := v1.Range{Start: start, End: end, Step: step}
+			value, err := ts.withRetry(ctx, ts.Expr, ep,
+				func(ctx context.Context) (model.Value, error) {
+					return api.QueryRange(ctx, ts.Expr, rng)
+				})
+			if err != nil {
+				return fmt.Errorf("Backfill of expression [%s] failed: %v", ts.Name, err)
+			}
+
+			matrix, ok := value.(model.Matrix)
+			if !ok {
+				return fmt.Errorf("Backfill of expression [%s] failed: unexpected result type %v", ts.Name, value.Type())
+			}
+			for _, s := range matrix {
+				r.add(s, o)
+			}
+		}
+
+		r.print(fmt.Sprintf("%s step=%v", ts.Expr, step))
+	}
+
+	return nil
+}
+
+// timeOption parses option as RFC3339, unix seconds, or a duration (e.g. 7d) before now
+func timeOption(option string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, option); err == nil {
+		return t, nil
+	}
+	if secs, err := strconv.ParseFloat(option, 64); err == nil {
+		return time.Unix(0, int64(secs*float64(time.Second))), nil
+	}
+	d, err := model.ParseDuration(option)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid time [%s], expected RFC3339, unix seconds or a duration (e.g. 7d) ago", option)
+	}
+	return now.Add(-time.Duration(d)), nil
+}
@@ -0,0 +1,66 @@
+// backfill_test.go
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// the range is queried in chunks of maxRangePoints, without overlap
+func TestBackfillChunks(t *testing.T) {
+	ts := testExpression(t, "x")
+	o := defaultOptions()
+	o.start = time.Unix(1546300800, 0)
+	o.end = o.start.Add(25000 * time.Second)
+	o.step = time.Second
+
+	var ranges []v1.Range
+	points := 0
+	api := fakeAPI{queryRange: func(query string, r v1.Range) (model.Value, error) {
+		assertEqual(t, "x", query)
+		ranges = append(ranges, r)
+		s := &model.SampleStream{Metric: model.Metric{"a": "1"}}
+		for tm := r.Start; !tm.After(r.End); tm = tm.Add(r.Step) {
+			s.Values = append(s.Values, model.SamplePair{Timestamp: model.TimeFromUnixNano(tm.UnixNano()), Value: 1})
+		}
+		points += len(s.Values)
+		return model.Matrix{s}, nil
+	}}
+	assertEqual(t, nil, backfill(context.Background(), []TSExpression{ts}, o, api))
+
+	assertEqual(t, 3, len(ranges))
+	assertEqual(t, o.start, ranges[0].Start)
+	assertEqual(t, o.start.Add((maxRangePoints-1)*time.Second), ranges[0].End)
+	assertEqual(t, o.start.Add(maxRangePoints*time.Second), ranges[1].Start)
+	assertEqual(t, o.start.Add(2*maxRangePoints*time.Second), ranges[2].Start)
+	assertEqual(t, o.end, ranges[2].End)
+	assertEqual(t, 25001, points)
+
+	// the step defaults to the interval
+	ranges = nil
+	o.step = 0
+	o.end = o.start.Add(time.Hour)
+	assertEqual(t, nil, backfill(context.Background(), []TSExpression{ts}, o, api))
+	assertEqual(t, 1, len(ranges))
+	assertEqual(t, ts.Interval, ranges[0].Step)
+}
+
+func TestBackfillUnexpectedType(t *testing.T) {
+	ts := testExpression(t, "x")
+	noRetries := 0
+	ts.Retries = &noRetries
+	o := defaultOptions()
+	o.start = time.Unix(1546300800, 0)
+	o.end = o.start.Add(time.Hour)
+
+	api := fakeAPI{queryRange: func(query string, r v1.Range) (model.Value, error) {
+		return model.Vector{}, nil
+	}}
+	err := backfill(context.Background(), []TSExpression{ts}, o, api)
+	assertEqual(t, true, err != nil && strings.Contains(err.Error(), "unexpected result type vector"))
+}
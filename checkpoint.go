@@ -0,0 +1,122 @@
+// checkpoint.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/jshaughn/outlier/nelson"
+)
+
+// seriesCheckpoint is the persisted state of a tracked TS
+type seriesCheckpoint struct {
+	Expression string          `json:"expression"`
+	Metric     model.Metric    `json:"metric"`
+	Data       nelson.Snapshot `json:"data"`
+}
+
+// checkpoint() is expected to execute as a goroutine, it returns when ctx is cancelled
+func checkpoint(ctx context.Context, o options) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(o.checkpoint):
+		}
+		if err := saveCheckpoint(o.stateFile); err != nil {
+			fmt.Printf("Checkpoint failed: %v\n", err)
+		}
+	}
+}
+
+// saveCheckpoint writes the state of every tracked TS to path. The file is replaced
+// atomically so a crash mid-write leaves the previous checkpoint intact.
+func saveCheckpoint(path string) error {
+	checkpoints := make(map[string]seriesCheckpoint)
+	nelsonMap.Range(
+		func(k interface{}, v interface{}) bool {
+			ser := v.(*series)
+			ser.Lock()
+			snapshot, err := ser.data.Snapshot()
+			ser.Unlock()
+			if err != nil {
+				fmt.Printf("Not checkpointing TS %s: %v\n", k, err)
+				return true
+			}
+			checkpoints[k.(string)] = seriesCheckpoint{
+				Expression: ser.expression,
+				Metric:     ser.metric,
+				Data:       snapshot,
+			}
+			return true
+		})
+
+	bytes, err := json.Marshal(checkpoints)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(bytes); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadCheckpoint restores the tracked TS from path, if it exists. A TS whose TSExpression is
+// no longer defined, or whose state does not match the TSExpression (e.g. a changed
+// sampleSize), starts fresh.
+func loadCheckpoint(path string, tsExpressions []TSExpression, o options) error {
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var checkpoints map[string]seriesCheckpoint
+	if err = json.Unmarshal(bytes, &checkpoints); err != nil {
+		return fmt.Errorf("Invalid state file [%s]: %v", path, err)
+	}
+
+	byName := make(map[string]TSExpression, len(tsExpressions))
+	for _, ts := range tsExpressions {
+		byName[ts.Name] = ts
+	}
+
+	restored := 0
+	for k, c := range checkpoints {
+		ts, ok := byName[c.Expression]
+		if !ok {
+			fmt.Printf("Discarding state for TS %s: expression [%s] not defined\n", k, c.Expression)
+			continue
+		}
+		ser := newSeries(c.Metric, ts, o)
+		if err = ser.data.Restore(c.Data); err != nil {
+			fmt.Printf("Discarding state for TS %s: %v\n", k, err)
+			continue
+		}
+		nelsonMap.Store(k, ser)
+		restored++
+	}
+	fmt.Printf("Restored state for [%v] TS from %s\n", restored, path)
+
+	return nil
+}
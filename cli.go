@@ -0,0 +1,482 @@
+// cli.go
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jshaughn/outlier/nelson"
+	"github.com/jshaughn/outlier/simulate"
+)
+
+// Exit codes
+const (
+	exitOK = 0
+	// exitError is returned when a command fails
+	exitError = 1
+	// exitUsage is returned for unknown commands, and invalid flags or arguments
+	exitUsage = 2
+)
+
+// command is an outlier subcommand. Each command defines only the flags it uses.
+type command struct {
+	name string
+	// args describes the positional arguments, if any
+	args string
+	// summary is a one line description, description is shown by the command's usage
+	summary     string
+	description string
+	// flags defines the command's flags, bound to the fields of o
+	flags func(fs *flag.FlagSet, o *options)
+	// parse handles the positional arguments, remaining after the flags are parsed
+	parse func(args []string, o *options) error
+	// validate checks the parsed options, failures are usage errors
+	validate func(o options) error
+	run      func(o options) error
+}
+
+var commands = []*command{
+	{
+		name:    "run",
+		summary: "Watch the expressions and send rule violations to the sinks (default)",
+		description: `Runs the daemon: queries Prometheus every interval, evaluates each resulting TS
+against the rules and sends the alert state transitions to the log and any configured
+sinks. Detector state and metrics are served on the endpoint. The config is reloaded on
+SIGHUP, the daemon shuts down on SIGINT or SIGTERM.`,
+		flags: func(fs *flag.FlagSet, o *options) {
+			detectorFlags(fs, o)
+			queryFlags(fs, o)
+			runFlags(fs, o)
+		},
+		validate: func(o options) error {
+			return firstError(validateDetector(o), validateQuery(o), validateRun(o))
+		},
+		run: daemon,
+	},
+	{
+		name:    "backfill",
+		summary: "Evaluate the expressions over a past time range and print a report",
+		description: `Evaluates the expressions over [start, end] using range queries, and prints a
+report of every violation. State is not shared with, or persisted for, the daemon.`,
+		flags: func(fs *flag.FlagSet, o *options) {
+			detectorFlags(fs, o)
+			queryFlags(fs, o)
+			fs.Var(newTimeValue(&o.start, "7d"), "start", "Start `time` of the range: RFC3339, unix seconds, or a duration (Xh, Xd, Xw) ago.")
+			fs.Var(newTimeValue(&o.end, "0s"), "end", "End `time` of the range: RFC3339, unix seconds, or a duration (Xh, Xd, Xw) ago.")
+			fs.DurationVar(&o.step, "step", o.step, "Query resolution step (Xs). Defaults to the expression interval.")
+		},
+		validate: func(o options) error {
+			if !o.start.Before(o.end) {
+				return errors.New("Start must be before End")
+			}
+			if o.step < 0 {
+				return errors.New("Step must be >= 0")
+			}
+			return firstError(validateDetector(o), validateQuery(o))
+		},
+		run: backfillCmd,
+	},
+	{
+		name:    "eval",
+		args:    "[file]",
+		summary: "Evaluate time series read from a file or stdin and print a report",
+		description: `Evaluates the time series read from the file, or stdin if not given, and prints a
+report of every violation. Prometheus is not required. The input format is csv, jsonl
+or prom, by default implied by the file extension.`,
+		flags: func(fs *flag.FlagSet, o *options) {
+			detectorFlags(fs, o)
+			fs.StringVar(&o.format, "format", o.format, "Input format (csv, jsonl or prom). Required when reading stdin.")
+		},
+		parse: func(args []string, o *options) error {
+			switch len(args) {
+			case 0:
+			case 1:
+				o.input = args[0]
+			default:
+				return fmt.Errorf("Expected at most one input file, got %v", args)
+			}
+			return nil
+		},
+		validate: func(o options) error {
+			if _, err := inputFormat(o); err != nil {
+				return err
+			}
+			return validateDetector(o)
+		},
+		run: eval,
+	},
+	{
+		name:    "simulate",
+		summary: "Export, or print, synthetic time series for demos and testing",
+		description: `Exports a gauge on the endpoint with a TS for each waveform, labeled
+waveform=<name>, updated every interval. With -points the values are instead printed
+to stdout as csv, which can be piped to eval.`,
+		flags: func(fs *flag.FlagSet, o *options) {
+			fs.Var(newWaveformsValue(&o.simulate, "all"), "waveforms", "Comma-separated list of `waveforms`: stable, noisy, drift, step, spike, oscillation, seasonal, or all.")
+			fs.StringVar(&o.simMetric, "metric", o.simMetric, "The simulated gauge name.")
+			fs.DurationVar(&o.simInterval, "interval", o.simInterval, "Interval (Xs) between simulated data points.")
+			fs.StringVar(&o.endpoint, "endpoint", o.endpoint, "The scrape endpoint.")
+			fs.IntVar(&o.simPoints, "points", o.simPoints, "Print this many data points per waveform, ending now, instead of serving the gauge.")
+			fs.Int64Var(&o.simSeed, "seed", o.simSeed, "Random seed for printed data points.")
+		},
+		validate: func(o options) error {
+			if len(o.simulate) == 0 {
+				return errors.New("Waveforms must be set")
+			}
+			if o.simMetric == "" {
+				return errors.New("Metric must be set")
+			}
+			if o.simInterval <= 0 {
+				return errors.New("Interval must be > 0")
+			}
+			if o.simPoints < 0 {
+				return errors.New("Points must be >= 0")
+			}
+			return nil
+		},
+		run: simulateCmd,
+	},
+	{
+		name:    "validate",
+		args:    "config",
+		summary: "Check a config file and exit",
+		description: `Loads the config file, defaulting unset fields from the flags as the daemon would,
+and prints the resulting expressions and sinks. Exits non-zero if the config is
+invalid.`,
+		flags: func(fs *flag.FlagSet, o *options) {
+			detectorFlags(fs, o)
+			queryFlags(fs, o)
+		},
+		parse: func(args []string, o *options) error {
+			if len(args) == 1 {
+				o.config = args[0]
+			}
+			if o.config == "" || len(args) > 1 {
+				return errors.New("Expected a single config file")
+			}
+			return nil
+		},
+		validate: func(o options) error {
+			return firstError(validateDetector(o), validateQuery(o))
+		},
+		run: validateConfig,
+	},
+}
+
+// defaultOptions returns the options before any flags are parsed
+func defaultOptions() options {
+	server, ok := os.LookupEnv("PROMETHEUS_SERVER")
+	if !ok {
+		server = "http://localhost:9090"
+	}
+	return options{
+		server:       server,
+		sampleSize:   50,
+		interval:     30 * time.Second,
+		endpoint:     ":8080",
+		rules:        "common",
+		trim:         nelson.DefaultTrim,
+		location:     time.UTC,
+		resolveAfter: 1,
+		maxSeries:    10000,
+		seriesTTL:    10,
+		adminToken:   os.Getenv("OUTLIER_ADMIN_TOKEN"),
+		muteFile:     "mutes.json",
+		checkpoint:   time.Minute,
+		input:        "-",
+		simMetric:    "response_time",
+		simInterval:  5 * time.Second,
+		simSeed:      1,
+		queryTimeout: 10 * time.Second,
+		queryRetries: 3,
+		queryBackoff: time.Second,
+	}
+}
+
+// detectorFlags define the options of commands evaluating TS
+func detectorFlags(fs *flag.FlagSet, o *options) {
+	fs.IntVar(&o.sampleSize, "sampleSize", o.sampleSize, "Number of data points used to calculate mean, standard deviation, etc.")
+	fs.StringVar(&o.rules, "rules", o.rules, fmt.Sprintf("The rule set to evaluate, one of %v", nelson.RuleSetNames()))
+	fs.Var(&funcValue{
+		set: func(s string) (err error) { o.baseline.Mode, err = nelson.ParseBaselineMode(s); return },
+		str: func() string { return o.baseline.Mode.String() },
+	}, "baseline", "Baseline `mode` (frozen, sliding, ewma or periodic) determining how mean and stddev evolve.")
+	fs.IntVar(&o.baseline.Window, "baselineWindow", o.baseline.Window, "Number of data points used by the sliding baseline. Defaults to sampleSize.")
+	fs.Float64Var(&o.baseline.Alpha, "baselineAlpha", o.baseline.Alpha, "Smoothing factor (0,1] used by the ewma baseline. Defaults to 2/(sampleSize+1).")
+	fs.DurationVar(&o.baseline.Period, "baselinePeriod", o.baseline.Period, "Re-baseline period (Xm, Xh) used by the periodic baseline. Defaults to every sampleSize data points.")
+	fs.Var(&funcValue{
+		set: func(s string) (err error) { o.estimator, err = nelson.ParseEstimator(s); return },
+		str: func() string { return o.estimator.String() },
+	}, "estimator", "Baseline `estimator` (standard, mad, trimmed or winsorized). Robust estimators limit the influence of outliers in the baseline.")
+	fs.Float64Var(&o.trim, "trim", o.trim, "Fraction of data points trimmed from each end by the trimmed and winsorized estimators.")
+	fs.Var(&funcValue{
+		set: func(s string) (err error) { o.seasonality, err = nelson.ParseSeasonality(s); return },
+		str: func() string { return o.seasonality.String() },
+	}, "seasonality", "Seasonal baselines, by `period` (none, hour-of-day, day-of-week or hour-of-week). Each bucket requires sampleSize data points.")
+	fs.Var(&funcValue{
+		set: func(s string) (err error) { o.location, err = time.LoadLocation(s); return },
+		str: func() string { return o.location.String() },
+	}, "timezone", "Time `zone` (e.g. America/New_York) used to determine seasonal buckets.")
+	fs.DurationVar(&o.alertFor, "for", o.alertFor, "Duration (Xs, Xm) a rule must keep violating before it fires. Violating rules are pending until then.")
+	fs.IntVar(&o.resolveAfter, "resolveAfter", o.resolveAfter, "Number of consecutive non-violating data points before a firing rule is resolved.")
+}
+
+// queryFlags define the options of commands querying Prometheus
+func queryFlags(fs *flag.FlagSet, o *options) {
+	fs.StringVar(&o.server, "server", o.server, "Prometheus server URL (can be set via PROMETHEUS_SERVER environment variable).")
+	fs.StringVar(&o.config, "config", o.config, "YAML or JSON file defining the watched expressions. If not set only response_time is watched.")
+	fs.DurationVar(&o.interval, "interval", o.interval, "Query interval (Xs). Recommended 2 times the scrape interval.")
+	fs.DurationVar(&o.offset, "offset", o.offset, "Offset (Xm, Xh) from now to start metric sample collection.")
+	fs.DurationVar(&o.queryTimeout, "queryTimeout", o.queryTimeout, "Timeout (Xs) for each query attempt.")
+	fs.IntVar(&o.queryRetries, "queryRetries", o.queryRetries, fmt.Sprintf("Number of times (at most %d) a failed query is retried before its TS are marked stale.", maxRetries))
+	fs.DurationVar(&o.queryBackoff, "queryBackoff", o.queryBackoff, "Initial backoff (Xs) between query retries, doubled for each retry up to the interval.")
+}
+
+// runFlags define the options of the daemon
+func runFlags(fs *flag.FlagSet, o *options) {
+	fs.StringVar(&o.endpoint, "endpoint", o.endpoint, "The scrape endpoint.")
+	fs.DurationVar(&o.reloadInterval, "reloadInterval", o.reloadInterval, "Interval (Xs, Xm) between checks of the config file for changes. Disabled if 0, the config is always reloaded on SIGHUP.")
+	fs.IntVar(&o.maxSeries, "maxSeries", o.maxSeries, "Maximum number of TS tracked across all expressions, the least recently seen TS is evicted to track a new TS. Unlimited if 0.")
+	fs.IntVar(&o.seriesTTL, "seriesTTL", o.seriesTTL, "Number of intervals after which a TS no longer returned by its expression is evicted. Disabled if 0.")
+	// the token is never printed, it defaults from the environment
+	fs.Var(&funcValue{
+		set: func(s string) error { o.adminToken = s; return nil },
+		str: func() string { return "" },
+	}, "adminToken", "Bearer `token` required by the admin API (can be set via OUTLIER_ADMIN_TOKEN environment variable). The admin API is disabled if not set.")
+	fs.StringVar(&o.muteFile, "muteFile", o.muteFile, "File used to persist rule mutes across restarts. Not persisted if empty.")
+	fs.StringVar(&o.stateFile, "stateFile", o.stateFile, "File used to persist detector state across restarts. Disabled if not set.")
+	fs.DurationVar(&o.checkpoint, "checkpoint", o.checkpoint, "Interval (Xs, Xm) between state checkpoints to the stateFile.")
+	fs.Var(newWaveformsValue(&o.simulate, ""), "simulate", "Also export a simulated response_time gauge, with a TS for each waveform (comma-separated list of `waveforms`: stable, noisy, drift, step, spike, oscillation, seasonal, or all). Disabled if not set, see the simulate command.")
+	fs.DurationVar(&o.simInterval, "simulateInterval", o.simInterval, "Interval (Xs) between simulated data points.")
+}
+
+func validateDetector(o options) error {
+	if o.sampleSize <= 0 {
+		return errors.New("SampleSize must be > 0")
+	}
+	if o.alertFor < 0 {
+		return errors.New("For must be >= 0")
+	}
+	if o.resolveAfter <= 0 {
+		return errors.New("ResolveAfter must be > 0")
+	}
+	_, err := nelson.LookupRuleSet(o.rules)
+	return err
+}
+
+func validateQuery(o options) error {
+	if o.server == "" {
+		return errors.New("Server must be set")
+	}
+	if o.interval <= 0 {
+		return errors.New("Interval must be > 0")
+	}
+	if o.queryTimeout <= 0 {
+		return errors.New("QueryTimeout must be > 0")
+	}
+	if o.queryRetries < 0 || o.queryRetries > maxRetries {
+		return fmt.Errorf("QueryRetries must be >= 0 and <= %d", maxRetries)
+	}
+	if o.queryBackoff <= 0 {
+		return errors.New("QueryBackoff must be > 0")
+	}
+	return nil
+}
+
+func validateRun(o options) error {
+	if o.maxSeries < 0 {
+		return errors.New("MaxSeries must be >= 0")
+	}
+	if o.seriesTTL < 0 {
+		return errors.New("SeriesTTL must be >= 0")
+	}
+	if o.simInterval <= 0 {
+		return errors.New("SimulateInterval must be > 0")
+	}
+	if o.stateFile != "" && o.checkpoint <= 0 {
+		return errors.New("Checkpoint must be > 0")
+	}
+	if o.reloadInterval < 0 {
+		return errors.New("ReloadInterval must be >= 0")
+	}
+	return nil
+}
+
+// firstError returns the first non-nil error
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCLI executes the command named by args[0] and returns the exit code. With no
+// command, or if args starts with a flag, the run command is executed.
+func runCLI(args []string, stderr io.Writer) int {
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	switch name {
+	case "run":
+		// -h before any command shows the commands, not the run flags
+		if len(args) > 0 && isHelp(args[0]) {
+			usage(stderr)
+			return exitOK
+		}
+	case "help":
+		if len(args) == 0 {
+			usage(stderr)
+			return exitOK
+		}
+		if cmd := lookupCommand(args[0]); cmd != nil {
+			o := defaultOptions()
+			cmd.flagSet(&o, stderr).Usage()
+			return exitOK
+		}
+		name = args[0]
+	}
+
+	cmd := lookupCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(stderr, "Error: unknown command [%s]\n\n", name)
+		usage(stderr)
+		return exitUsage
+	}
+
+	o := defaultOptions()
+	fs := cmd.flagSet(&o, stderr)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		// the error and usage are printed by fs
+		return exitUsage
+	}
+	var err error
+	if cmd.parse != nil {
+		err = cmd.parse(fs.Args(), &o)
+	} else if fs.NArg() > 0 {
+		err = fmt.Errorf("Unexpected arguments %v", fs.Args())
+	}
+	if err == nil && cmd.validate != nil {
+		err = cmd.validate(o)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\nRun 'outlier %s -h' for usage.\n", err, cmd.name)
+		return exitUsage
+	}
+
+	if err = cmd.run(o); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// flagSet returns the command's flags bound to o, printing errors and usage to output
+func (cmd *command) flagSet(o *options, output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	if cmd.flags != nil {
+		cmd.flags(fs, o)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(output, "Usage: outlier %s [flags] %s\n\n%s\n\nFlags:\n", cmd.name, cmd.args, cmd.description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func lookupCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func isHelp(arg string) bool {
+	switch arg {
+	case "-h", "-help", "--help":
+		return true
+	}
+	return false
+}
+
+// usage prints the commands
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: outlier <command> [flags]\n\nDetects outliers in Prometheus time series using Nelson rules.\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun 'outlier help <command>' for the flags of a command.\n")
+}
+
+// funcValue is a flag.Value parsed by set and printed by str
+type funcValue struct {
+	set func(string) error
+	str func() string
+}
+
+func (v *funcValue) Set(s string) error {
+	return v.set(s)
+}
+
+func (v *funcValue) String() string {
+	// flag calls String on a zero value to detect defaults
+	if v.str == nil {
+		return ""
+	}
+	return v.str()
+}
+
+// newTimeValue returns a flag.Value setting t from RFC3339, unix seconds, or a duration
+// ago. t is set from def, which must be valid.
+func newTimeValue(t *time.Time, def string) flag.Value {
+	text := def
+	v := &funcValue{
+		set: func(s string) (err error) {
+			if *t, err = timeOption(s, time.Now()); err == nil {
+				text = s
+			}
+			return err
+		},
+		str: func() string { return text },
+	}
+	if err := v.Set(def); err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// newWaveformsValue returns a flag.Value setting ws from a comma-separated list of
+// waveforms, or none if empty. ws is set from def, which must be valid.
+func newWaveformsValue(ws *[]simulate.Waveform, def string) flag.Value {
+	text := def
+	v := &funcValue{
+		set: func(s string) (err error) {
+			if s == "" {
+				*ws, text = nil, s
+				return nil
+			}
+			if *ws, err = simulate.ParseWaveforms(s); err == nil {
+				text = s
+			}
+			return err
+		},
+		str: func() string { return text },
+	}
+	if err := v.Set(def); err != nil {
+		panic(err)
+	}
+	return v
+}
@@ -0,0 +1,151 @@
+// cli_test.go
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jshaughn/outlier/nelson"
+)
+
+// cli runs the command line, returning the exit code and stderr
+func cli(args ...string) (int, string) {
+	var stderr bytes.Buffer
+	code := runCLI(args, &stderr)
+	return code, stderr.String()
+}
+
+// testFile writes content to name in a new temporary directory, returning its path and a
+// function removing it
+func testFile(t *testing.T, name, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "outlier")
+	assertEqual(t, nil, err)
+	path := filepath.Join(dir, name)
+	assertEqual(t, nil, ioutil.WriteFile(path, []byte(content), 0644))
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestCLIHelp(t *testing.T) {
+	for _, args := range [][]string{{"help"}, {"-h"}, {"--help"}} {
+		code, out := cli(args...)
+		assertEqual(t, exitOK, code)
+		assertEqual(t, true, strings.HasPrefix(out, "Usage: outlier <command> [flags]"))
+		for _, cmd := range commands {
+			assertEqual(t, true, strings.Contains(out, "  "+cmd.name))
+		}
+	}
+
+	code, out := cli("help", "eval")
+	assertEqual(t, exitOK, code)
+	assertEqual(t, true, strings.HasPrefix(out, "Usage: outlier eval [flags] [file]"))
+	assertEqual(t, true, strings.Contains(out, "-sampleSize int"))
+	assertEqual(t, true, strings.Contains(out, "(default 50)"))
+	assertEqual(t, false, strings.Contains(out, "-server"))
+
+	code, out = cli("backfill", "-h")
+	assertEqual(t, exitOK, code)
+	assertEqual(t, true, strings.HasPrefix(out, "Usage: outlier backfill [flags]"))
+	assertEqual(t, true, strings.Contains(out, "-step"))
+}
+
+// the admin token is never shown, even when set in the environment
+func TestCLIHelpToken(t *testing.T) {
+	saved, ok := os.LookupEnv("OUTLIER_ADMIN_TOKEN")
+	os.Setenv("OUTLIER_ADMIN_TOKEN", "secret")
+	defer func() {
+		if ok {
+			os.Setenv("OUTLIER_ADMIN_TOKEN", saved)
+		} else {
+			os.Unsetenv("OUTLIER_ADMIN_TOKEN")
+		}
+	}()
+
+	code, out := cli("help", "run")
+	assertEqual(t, exitOK, code)
+	assertEqual(t, true, strings.Contains(out, "-adminToken"))
+	assertEqual(t, false, strings.Contains(out, "secret"))
+}
+
+func TestCLIUsageErrors(t *testing.T) {
+	code, out := cli("frobnicate")
+	assertEqual(t, exitUsage, code)
+	assertEqual(t, true, strings.HasPrefix(out, "Error: unknown command [frobnicate]"))
+	code, _ = cli("help", "frobnicate")
+	assertEqual(t, exitUsage, code)
+
+	code, out = cli("eval", "-bogus")
+	assertEqual(t, exitUsage, code)
+	assertEqual(t, true, strings.Contains(out, "flag provided but not defined: -bogus"))
+	// flags are defined per command
+	code, _ = cli("eval", "-server", "http://localhost:9090")
+	assertEqual(t, exitUsage, code)
+
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"eval", "-sampleSize", "0", "in.csv"}, "SampleSize must be > 0"},
+		{[]string{"eval", "a.csv", "b.csv"}, "Expected at most one input file, got [a.csv b.csv]"},
+		{[]string{"eval", "-rules", "bogus", "in.csv"}, "Unknown rule set [bogus]"},
+		{[]string{"backfill", "-start", "1h", "-end", "2h"}, "Start must be before End"},
+		{[]string{"backfill", "-step", "-1s"}, "Step must be >= 0"},
+		{[]string{"run", "-queryRetries", "11"}, "QueryRetries must be >= 0 and <= 10"},
+		{[]string{"-maxSeries", "-1"}, "MaxSeries must be >= 0"},
+		{[]string{"simulate", "-interval", "0s"}, "Interval must be > 0"},
+		{[]string{"validate"}, "Expected a single config file"},
+		{[]string{"validate", "a.yaml", "b.yaml"}, "Expected a single config file"},
+		{[]string{"simulate", "extra"}, "Unexpected arguments [extra]"},
+	} {
+		code, out = cli(c.args...)
+		assertEqual(t, exitUsage, code)
+		assertEqual(t, true, strings.HasPrefix(out, "Error: "+c.err))
+		assertEqual(t, true, strings.Contains(out, "for usage."))
+	}
+}
+
+func TestCLIValidate(t *testing.T) {
+	path, remove := testFile(t, "config.yaml", `
+expressions:
+  - name: latency
+    expr: 'histogram_quantile(0.9, rate(latency_bucket[5m]))'
+    interval: 1m
+  - expr: response_time
+`)
+	defer remove()
+	code, _ := cli("validate", path)
+	assertEqual(t, exitOK, code)
+	code, _ = cli("validate", "-config", path)
+	assertEqual(t, exitOK, code)
+
+	code, out := cli("validate", filepath.Join(filepath.Dir(path), "missing.yaml"))
+	assertEqual(t, exitError, code)
+	assertEqual(t, true, strings.HasPrefix(out, "Error: "))
+
+	invalid, removeInvalid := testFile(t, "config.yaml", "expressions:\n  - expr: x\n    sampleSize: -1\n")
+	defer removeInvalid()
+	code, out = cli("validate", invalid)
+	assertEqual(t, exitError, code)
+	assertEqual(t, true, strings.Contains(out, "SampleSize must be > 0"))
+}
+
+func TestCLIEval(t *testing.T) {
+	path, remove := testFile(t, "in.csv", "timestamp,metric,value\n1546300800,x,1\n1546300810,x,2\n")
+	defer remove()
+	code, _ := cli("eval", "-sampleSize", "2", path)
+	assertEqual(t, exitOK, code)
+
+	code, out := cli("eval", filepath.Join(filepath.Dir(path), "missing.csv"))
+	assertEqual(t, exitError, code)
+	assertEqual(t, true, strings.HasPrefix(out, "Error: "))
+}
+
+// the CLI default trim is the library default
+func TestDefaultOptions(t *testing.T) {
+	o := defaultOptions()
+	assertEqual(t, nelson.DefaultTrim, o.trim)
+	assertEqual(t, nil, firstError(validateDetector(o), validateQuery(o), validateRun(o)))
+}
@@ -0,0 +1,191 @@
+// config.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+
+	"github.com/jshaughn/outlier/nelson"
+	"github.com/jshaughn/outlier/sink"
+)
+
+// config is the content of the config file (YAML or JSON)
+type config struct {
+	Expressions []TSExpression `yaml:"expressions"`
+	// Sinks receive violations in addition to the log. They are not reloaded.
+	Sinks []sink.Config `yaml:"sinks"`
+}
+
+// readConfig parses the config file at path
+func readConfig(path string) (config, error) {
+	var c config
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+
+	if err = yaml.UnmarshalStrict(bytes, &c); err != nil {
+		return c, fmt.Errorf("Invalid config file [%s]: %v", path, err)
+	}
+	return c, nil
+}
+
+// loadConfig returns the TSExpressions defined in the config file at path. Unset
+// TSExpression fields default to the command line options.
+func loadConfig(path string, o options) ([]TSExpression, error) {
+	c, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Expressions) == 0 {
+		return nil, fmt.Errorf("Invalid config file [%s]: no expressions defined", path)
+	}
+
+	names := make(map[string]bool, len(c.Expressions))
+	for i := range c.Expressions {
+		ts := &c.Expressions[i]
+		if err = ts.init(o); err != nil {
+			return nil, fmt.Errorf("Invalid config file [%s]: expression [%d]: %v", path, i, err)
+		}
+		if names[ts.Name] {
+			return nil, fmt.Errorf("Invalid config file [%s]: duplicate expression name [%s]", path, ts.Name)
+		}
+		names[ts.Name] = true
+	}
+
+	return c.Expressions, nil
+}
+
+// loadSinks returns the log sink and any sinks defined in the config file at path
+func loadSinks(path string) (sink.Sinks, error) {
+	result := sink.Sinks{sink.Log{}}
+	if path == "" {
+		return result, nil
+	}
+
+	c, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	for i, sc := range c.Sinks {
+		s, err := sink.New(sc)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid config file [%s]: sink [%d]: %v", path, i, err)
+		}
+		fmt.Printf("Sending violations to sink [%v]\n", s)
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// validateConfig loads the expressions and sinks of the config file o.config and prints them
+func validateConfig(o options) error {
+	watched, err := loadConfig(o.config, o)
+	if err != nil {
+		return err
+	}
+	for _, ts := range watched {
+		fmt.Printf("Expression [%s]: expr=%s interval=%v offset=%v sampleSize=%d rules=%s for=%v resolveAfter=%d labels=%v instant=%v\n",
+			ts.Name, ts.Expr, ts.Interval, ts.offset(), ts.SampleSize, ts.Rules, ts.alertFor(), ts.ResolveAfter, ts.Labels, ts.instant())
+	}
+	if _, err = loadSinks(o.config); err != nil {
+		return err
+	}
+	fmt.Printf("Config file [%s] is valid\n", o.config)
+	return nil
+}
+
+// selector matches a PromQL instant vector selector, e.g. x or x{a="b"}, optionally
+// followed by offset and @ modifiers, e.g. x offset 5m @ end(). The first submatch is the
+// selector and the second the modifiers. Expressions containing anything else, including
+// a range selector, default to instant queries.
+var selector = regexp.MustCompile(`^\s*((?:[a-zA-Z_:][a-zA-Z0-9_:]*\s*(?:\{[^{}]*\})?|\{[^{}]*\}))` +
+	`((?:\s*\b(?i:offset)\s+-?(?:\d+(?:ms|[smhdwy]))+|\s*@\s*(?:[0-9.eE+-]+|start\(\)|end\(\)))*)\s*$`)
+
+// init defaults unset fields from the command line options and validates the TSExpression
+func (ts *TSExpression) init(o options) error {
+	if ts.Expr == "" {
+		return errors.New("Expr must be set")
+	}
+	if ts.Name == "" {
+		ts.Name = ts.Expr
+	}
+	if ts.Instant == nil {
+		instant := !selector.MatchString(ts.Expr)
+		ts.Instant = &instant
+	}
+	if ts.SampleSize == 0 {
+		ts.SampleSize = o.sampleSize
+	}
+	if ts.Interval == 0 {
+		ts.Interval = o.interval
+	}
+	if ts.Offset == nil {
+		offset := o.offset
+		ts.Offset = &offset
+	}
+	if ts.Rules == "" {
+		ts.Rules = o.rules
+	}
+	if ts.Timeout == 0 {
+		ts.Timeout = o.queryTimeout
+	}
+	if ts.Retries == nil {
+		retries := o.queryRetries
+		ts.Retries = &retries
+	}
+	if ts.Backoff == 0 {
+		ts.Backoff = o.queryBackoff
+	}
+	if ts.For == nil {
+		alertFor := o.alertFor
+		ts.For = &alertFor
+	}
+	if ts.ResolveAfter == 0 {
+		ts.ResolveAfter = o.resolveAfter
+	}
+
+	if ts.SampleSize <= 0 {
+		return errors.New("SampleSize must be > 0")
+	}
+	if ts.Interval <= 0 {
+		return errors.New("Interval must be > 0")
+	}
+	if ts.Timeout <= 0 {
+		return errors.New("Timeout must be > 0")
+	}
+	if ts.retries() < 0 || ts.retries() > maxRetries {
+		return fmt.Errorf("Retries must be >= 0 and <= %d", maxRetries)
+	}
+	if ts.Backoff <= 0 {
+		return errors.New("Backoff must be > 0")
+	}
+	if ts.alertFor() < 0 {
+		return errors.New("For must be >= 0")
+	}
+	if ts.ResolveAfter <= 0 {
+		return errors.New("ResolveAfter must be > 0")
+	}
+	rules, err := nelson.LookupRuleSet(ts.Rules)
+	if err != nil {
+		return err
+	}
+	ts.rules = rules
+
+	return nil
+}
+
+// matches returns true if the metric has all of the TSExpression's label filters
+func (ts TSExpression) matches(m model.Metric) bool {
+	for k, v := range ts.Labels {
+		if string(m[model.LabelName(k)]) != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,215 @@
+// config_test.go
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+
+	"github.com/jshaughn/outlier/sink"
+)
+
+// unset fields default to the options
+func TestLoadConfig(t *testing.T) {
+	path, remove := testFile(t, "config.yaml", `
+expressions:
+  - name: latency
+    expr: response_time{job="api"}
+    sampleSize: 20
+    interval: 1m
+    rules: westgard
+    labels:
+      instance: a
+  - expr: response_time
+`)
+	defer remove()
+	o := defaultOptions()
+	watched, err := loadConfig(path, o)
+	assertEqual(t, nil, err)
+	assertEqual(t, 2, len(watched))
+
+	ts := watched[0]
+	assertEqual(t, "latency", ts.Name)
+	assertEqual(t, 20, ts.SampleSize)
+	assertEqual(t, time.Minute, ts.Interval)
+	assertEqual(t, "westgard", ts.Rules)
+	assertEqual(t, true, ts.matches(model.Metric{"instance": "a", "job": "api"}))
+	assertEqual(t, false, ts.matches(model.Metric{"instance": "b", "job": "api"}))
+
+	ts = watched[1]
+	assertEqual(t, "response_time", ts.Name)
+	assertEqual(t, o.sampleSize, ts.SampleSize)
+	assertEqual(t, o.interval, ts.Interval)
+	assertEqual(t, o.rules, ts.Rules)
+	assertEqual(t, o.queryTimeout, ts.Timeout)
+	assertEqual(t, o.queryRetries, ts.retries())
+	assertEqual(t, o.queryBackoff, ts.Backoff)
+	assertEqual(t, o.resolveAfter, ts.ResolveAfter)
+	assertEqual(t, true, len(ts.rules) > 0)
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for _, c := range []struct {
+		content string
+		err     string
+	}{
+		{"expressions: []\n", "no expressions defined"},
+		{"expressions:\n  - expr: x\n  - expr: x\n", "duplicate expression name [x]"},
+		{"expressions:\n  - expr: x\n  - name: y\n", "expression [1]: Expr must be set"},
+		{"expressions:\n  - expr: x\n    rules: bogus\n", "expression [0]: Unknown rule set [bogus]"},
+		{"expressions:\n  - expr: x\n    interval: -1s\n", "expression [0]: Interval must be > 0"},
+		{"expressions:\n  - expr: x\n    bogus: 1\n", "field bogus not found"},
+		{"expressions: [", "Invalid config file"},
+	} {
+		path, remove := testFile(t, "config.yaml", c.content)
+		_, err := loadConfig(path, defaultOptions())
+		remove()
+		assertEqual(t, true, err != nil)
+		assertEqual(t, true, strings.Contains(err.Error(), c.err))
+	}
+
+	_, err := loadConfig("missing.yaml", defaultOptions())
+	assertEqual(t, true, err != nil)
+}
+
+// the log sink is always first
+func TestLoadSinks(t *testing.T) {
+	sinks, err := loadSinks("")
+	assertEqual(t, nil, err)
+	assertEqual(t, "[log]", fmt.Sprint(sinks))
+
+	path, remove := testFile(t, "config.yaml", `
+expressions:
+  - expr: x
+sinks:
+  - type: file
+    path: violations.jsonl
+  - type: webhook
+    url: http://localhost:9999/hook
+    rules: [Rule1]
+`)
+	defer remove()
+	sinks, err = loadSinks(path)
+	assertEqual(t, nil, err)
+	assertEqual(t, 3, len(sinks))
+	assertEqual(t, sink.Log{}, sinks[0])
+	_, filtered := sinks[2].(sink.Filtered)
+	assertEqual(t, true, filtered)
+
+	invalid, removeInvalid := testFile(t, "config.yaml", "expressions:\n  - expr: x\nsinks:\n  - type: pager\n")
+	defer removeInvalid()
+	_, err = loadSinks(invalid)
+	assertEqual(t, true, strings.Contains(err.Error(), "sink [0]: Unknown sink type [pager]"))
+}
+
+func TestSelector(t *testing.T) {
+	for _, expr := range []string{
+		"x",
+		` x{a="b", c=~"d|e"} `,
+		`{__name__="x"}`,
+		"x offset 5m",
+		`x{a="b"} offset 1h30m`,
+		`x{a="b"}offset -5m`,
+		"x OFFSET 5m",
+		"x @ 1609746000",
+		"x @ 1609746000.5",
+		"x @ start()",
+		"x offset 5m @ end()",
+		"x @ 100 offset 5m",
+	} {
+		assertEqual(t, true, selector.MatchString(expr))
+	}
+
+	for _, expr := range []string{
+		"x[5m]",
+		"x[5m] offset 5m",
+		"rate(x[5m])",
+		"sum(x)",
+		"x + y",
+		"x offset",
+		"xoffset 5m",
+		"x offset 5",
+		"x @ now()",
+		"1",
+	} {
+		assertEqual(t, false, selector.MatchString(expr))
+	}
+}
+
+// Instant defaults from Expr, unless set
+func TestInstant(t *testing.T) {
+	for _, c := range []struct {
+		config  string
+		instant bool
+	}{
+		{"expr: x", false},
+		{"expr: x offset 5m", false},
+		{"expr: 'rate(x[5m])'", true},
+		{"expr: x\ninstant: true", true},
+		{"expr: 'rate(x[5m])'\ninstant: false", false},
+	} {
+		var ts TSExpression
+		assertEqual(t, nil, yaml.UnmarshalStrict([]byte(c.config), &ts))
+		assertEqual(t, nil, ts.init(defaultOptions()))
+		assertEqual(t, c.instant, ts.instant())
+	}
+}
+
+// retries: 0 disables retries, rather than defaulting to the queryRetries option
+func TestRetriesUnset(t *testing.T) {
+	for _, c := range []struct {
+		config  string
+		retries int
+	}{
+		{"expr: x", defaultOptions().queryRetries},
+		{"expr: x\nretries: 0", 0},
+		{"expr: x\nretries: 5", 5},
+	} {
+		var ts TSExpression
+		assertEqual(t, nil, yaml.UnmarshalStrict([]byte(c.config), &ts))
+		assertEqual(t, nil, ts.init(defaultOptions()))
+		assertEqual(t, c.retries, ts.retries())
+	}
+}
+
+// for: 0s fires on the first violation, rather than defaulting to the alertFor option
+func TestForUnset(t *testing.T) {
+	o := defaultOptions()
+	o.alertFor = time.Minute
+	for _, c := range []struct {
+		config   string
+		alertFor time.Duration
+	}{
+		{"expr: x", time.Minute},
+		{"expr: x\nfor: 0s", 0},
+		{"expr: x\nfor: 5m", 5 * time.Minute},
+	} {
+		var ts TSExpression
+		assertEqual(t, nil, yaml.UnmarshalStrict([]byte(c.config), &ts))
+		assertEqual(t, nil, ts.init(o))
+		assertEqual(t, c.alertFor, ts.alertFor())
+	}
+}
+
+// offset: 0s queries the current time, rather than defaulting to the offset option
+func TestOffsetUnset(t *testing.T) {
+	o := defaultOptions()
+	o.offset = time.Minute
+	for _, c := range []struct {
+		config string
+		offset time.Duration
+	}{
+		{"expr: x", time.Minute},
+		{"expr: x\noffset: 0s", 0},
+		{"expr: x\noffset: 5m", 5 * time.Minute},
+	} {
+		var ts TSExpression
+		assertEqual(t, nil, yaml.UnmarshalStrict([]byte(c.config), &ts))
+		assertEqual(t, nil, ts.init(o))
+		assertEqual(t, c.offset, ts.offset())
+	}
+}
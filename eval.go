@@ -0,0 +1,59 @@
+// eval.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jshaughn/outlier/input"
+)
+
+// eval evaluates the time series read from o.input, a path or "-" for stdin, and prints
+// a report of every violation. The series are evaluated with the command line options,
+// Prometheus is not required.
+func eval(o options) error {
+	var r io.Reader = os.Stdin
+	if o.input != "-" {
+		f, err := os.Open(o.input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	format, err := inputFormat(o)
+	if err != nil {
+		return err
+	}
+
+	matrix, err := input.Read(r, format)
+	if err != nil {
+		return fmt.Errorf("Unable to read input [%s]: %v", o.input, err)
+	}
+
+	ts := TSExpression{Expr: o.input}
+	if err = ts.init(o); err != nil {
+		return err
+	}
+
+	report := newReport(ts)
+	for _, s := range matrix {
+		report.add(s, o)
+	}
+	report.print(fmt.Sprintf("format=%s", format))
+
+	return nil
+}
+
+// inputFormat returns the -format option or, if not set, the format implied by the input path
+func inputFormat(o options) (input.Format, error) {
+	if o.format != "" {
+		return input.ParseFormat(o.format)
+	}
+	if o.input == "-" {
+		return "", fmt.Errorf("Format must be set when reading stdin")
+	}
+	return input.FormatFor(o.input)
+}
@@ -0,0 +1,109 @@
+// evict.go
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/jshaughn/outlier/scrape"
+	"github.com/jshaughn/outlier/sink"
+)
+
+// trackLock serializes starting to track a TS, so maxSeries is not exceeded
+var trackLock sync.Mutex
+
+// track starts tracking the new series under key k, evicting the least recently seen TS if
+// o.maxSeries TS are already tracked. If the key is already tracked that series is returned.
+// The events resolving the evicted TS are returned to be sent once the caller releases its
+// locks.
+func track(k string, ser *series, o options, ep scrape.Scrape) (*series, []sink.Event) {
+	trackLock.Lock()
+	defer trackLock.Unlock()
+
+	if result, ok := nelsonMap.Load(k); ok {
+		return result.(*series), nil
+	}
+
+	var events []sink.Event
+	if o.maxSeries > 0 {
+		count := 0
+		var lruKey interface{}
+		var lru *series
+		var lruSeen time.Time
+		nelsonMap.Range(
+			func(k interface{}, v interface{}) bool {
+				count++
+				candidate := v.(*series)
+				candidate.Lock()
+				seen := candidate.lastSeen
+				candidate.Unlock()
+				if lru == nil || seen.Before(lruSeen) {
+					lruKey, lru, lruSeen = k, candidate, seen
+				}
+				return true
+			})
+		if count >= o.maxSeries {
+			fmt.Printf("Tracking [%v] TS, evicting least recently seen TS %s\n", count, lruKey)
+			events = forget(lruKey, lru, ep)
+			ep.Evicted(lru.expression, "lru")
+		}
+	}
+
+	fmt.Println("Start tracking TS ", k)
+	nelsonMap.Store(k, ser)
+	return ser, events
+}
+
+// tracked returns true if ser is tracked under key k. It is false once ser is forgotten, even
+// if a new series is tracked under k.
+func tracked(k string, ser *series) bool {
+	v, ok := nelsonMap.Load(k)
+	return ok && v.(*series) == ser
+}
+
+// evictExpired evicts the TS tracked for ts that have not been seen for o.seriesTTL intervals
+func (ts TSExpression) evictExpired(o options, ep scrape.Scrape) {
+	if o.seriesTTL <= 0 {
+		return
+	}
+
+	expired := time.Now().Add(-time.Duration(o.seriesTTL) * ts.Interval)
+	var events []sink.Event
+	nelsonMap.Range(
+		func(k interface{}, v interface{}) bool {
+			ser := v.(*series)
+			if ser.expression != ts.Name {
+				return true
+			}
+			ser.Lock()
+			seen := ser.lastSeen
+			ser.Unlock()
+			if seen.Before(expired) {
+				fmt.Printf("Evicting TS %s, not seen since %v\n", k, seen.Format(TF))
+				events = append(events, forget(k, ser, ep)...)
+				ep.Evicted(ts.Name, "ttl")
+			}
+			return true
+		})
+	send(events)
+}
+
+// forget discards the tracked series under key k, and its metrics. Its pending and firing
+// rules are resolved, the caller sends the returned events.
+func forget(k interface{}, ser *series, ep scrape.Scrape) []sink.Event {
+	nelsonMap.Delete(k)
+
+	ser.Lock()
+	events := ser.deliver(ser.data.ResolveAll(int64(model.Now())))
+	ser.Unlock()
+
+	rules := make([]string, len(ser.data.Rules))
+	for i, r := range ser.data.Rules {
+		rules[i] = r.Name
+	}
+	ep.DeleteSeries(ser.expression, ser.metric.String(), rules)
+	return events
+}
@@ -0,0 +1,151 @@
+// evict_test.go
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+
+	"github.com/jshaughn/outlier/nelson"
+	"github.com/jshaughn/outlier/scrape"
+)
+
+// firingStream returns a SampleStream for m with a baseline of sampleSize values, followed
+// by an outlier
+func firingStream(m model.Metric, sampleSize int) *model.SampleStream {
+	s := &model.SampleStream{Metric: m}
+	for i := 0; i <= sampleSize; i++ {
+		v := model.SampleValue(10 + i%2)
+		if i == sampleSize {
+			v = 1000
+		}
+		s.Values = append(s.Values, model.SamplePair{Timestamp: model.Time(1000 * (i + 1)), Value: v})
+	}
+	return s
+}
+
+// testSeries tracks a new series for ts and m, last seen at seen
+func testSeries(ts TSExpression, m model.Metric, seen time.Time) *series {
+	ser := newSeries(m, ts, defaultOptions())
+	ser.lastSeen = seen
+	nelsonMap.Store(seriesKey(ts.Name, m), ser)
+	return ser
+}
+
+// the least recently seen TS is evicted once maxSeries TS are tracked
+func TestTrackLRU(t *testing.T) {
+	defer resetTracked()
+	ts := testExpression(t, "x")
+	o := defaultOptions()
+	o.maxSeries = 2
+	now := time.Now()
+	a := testSeries(ts, model.Metric{"a": "1"}, now.Add(-time.Minute))
+	b := testSeries(ts, model.Metric{"a": "2"}, now)
+
+	existing, events := track(seriesKey("x", a.metric), newSeries(a.metric, ts, o), o, scrape.Scrape{})
+	assertEqual(t, a, existing)
+	assertEqual(t, 0, len(events))
+
+	m := model.Metric{"a": "3"}
+	c, _ := track(seriesKey("x", m), newSeries(m, ts, o), o, scrape.Scrape{})
+	assertEqual(t, false, tracked(seriesKey("x", a.metric), a))
+	assertEqual(t, true, tracked(seriesKey("x", b.metric), b))
+	assertEqual(t, true, tracked(seriesKey("x", m), c))
+}
+
+// only the TS of the expression not seen for seriesTTL intervals are evicted
+func TestEvictExpired(t *testing.T) {
+	defer resetTracked()
+	ts := testExpression(t, "x")
+	other := testExpression(t, "y")
+	o := defaultOptions()
+	o.seriesTTL = 2
+	now := time.Now()
+	expired := testSeries(ts, model.Metric{"a": "1"}, now.Add(-3*ts.Interval))
+	seen := testSeries(ts, model.Metric{"a": "2"}, now.Add(-ts.Interval))
+	otherExpired := testSeries(other, model.Metric{"a": "1"}, now.Add(-3*ts.Interval))
+
+	ts.evictExpired(o, scrape.Scrape{})
+	assertEqual(t, false, tracked(seriesKey("x", expired.metric), expired))
+	assertEqual(t, true, tracked(seriesKey("x", seen.metric), seen))
+	assertEqual(t, true, tracked(seriesKey("y", otherExpired.metric), otherExpired))
+
+	o.seriesTTL = 0
+	ts.evictExpired(o, scrape.Scrape{})
+	assertEqual(t, true, tracked(seriesKey("x", seen.metric), seen))
+}
+
+// forgetting a TS resolves its firing rules, and a TS forgotten while processed is discarded
+func TestForget(t *testing.T) {
+	defer resetTracked()
+	ts := TSExpression{Expr: "x", SampleSize: 10}
+	assertEqual(t, nil, ts.init(defaultOptions()))
+	m := model.Metric{"a": "1"}
+	k := seriesKey("x", m)
+	ts.processSampleStream(firingStream(m, ts.SampleSize), defaultOptions(), scrape.Scrape{})
+	v, _ := nelsonMap.Load(k)
+	ser := v.(*series)
+
+	events := forget(k, ser, scrape.Scrape{})
+	assertEqual(t, false, tracked(k, ser))
+	assertEqual(t, true, len(events) > 0)
+	for _, e := range events {
+		assertEqual(t, nelson.Resolved.String(), e.State)
+		assertEqual(t, "x", e.Expression)
+		assertEqual(t, "1", e.Labels["a"])
+	}
+
+	// a new series tracked under the same key is not the forgotten one
+	ts.processSampleStream(testStream(m, 20000), defaultOptions(), scrape.Scrape{})
+	assertEqual(t, false, tracked(k, ser))
+	v, _ = nelsonMap.Load(k)
+	assertEqual(t, int64(20000), v.(*series).data.LastTime())
+	assertEqual(t, int64(11000), ser.data.LastTime())
+}
+
+// ruleCounted returns whether a nelson_rule value is reported for ts in the registry
+func ruleCounted(t *testing.T, r *prometheus.Registry, ts string) bool {
+	families, err := r.Gather()
+	assertEqual(t, nil, err)
+	for _, f := range families {
+		if f.GetName() != "nelson_rule" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "ts" && l.GetValue() == ts {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// the rule counts of a forgotten TS are deleted once no expression tracks the TS
+func TestForgetRuleCounts(t *testing.T) {
+	defer resetTracked()
+	_, restore := testSinks()
+	defer restore()
+	r := prometheus.NewRegistry()
+	scrape.Register(r)
+
+	x := TSExpression{Expr: "x", SampleSize: 10}
+	assertEqual(t, nil, x.init(defaultOptions()))
+	y := TSExpression{Expr: "y", SampleSize: 10}
+	assertEqual(t, nil, y.init(defaultOptions()))
+	m := model.Metric{"forget": "rules"}
+	x.processSampleStream(firingStream(m, x.SampleSize), defaultOptions(), scrape.Scrape{})
+	y.processSampleStream(firingStream(m, y.SampleSize), defaultOptions(), scrape.Scrape{})
+	assertEqual(t, true, ruleCounted(t, r, m.String()))
+
+	v, _ := nelsonMap.Load(seriesKey("x", m))
+	forget(seriesKey("x", m), v.(*series), scrape.Scrape{})
+	assertEqual(t, true, ruleCounted(t, r, m.String()))
+
+	v, _ = nelsonMap.Load(seriesKey("y", m))
+	forget(seriesKey("y", m), v.(*series), scrape.Scrape{})
+	assertEqual(t, false, ruleCounted(t, r, m.String()))
+}
@@ -0,0 +1,288 @@
+// httpapi.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/jshaughn/outlier/matchers"
+	"github.com/jshaughn/outlier/nelson"
+)
+
+// historySize is the number of recent violations kept per TS, for the API
+const historySize = 50
+
+// seriesSummary describes a tracked TS
+type seriesSummary struct {
+	ID                string         `json:"id"`
+	Expression        string         `json:"expression"`
+	Labels            model.Metric   `json:"labels"`
+	Ready             bool           `json:"ready"`
+	SamplesUntilReady int            `json:"samplesUntilReady"`
+	Stale             bool           `json:"stale"`
+	LastSeen          time.Time      `json:"lastSeen"`
+	LastTime          int64          `json:"lastTime"` // unix time in ms of the newest data point
+	Violations        map[string]int `json:"violations"`
+	Firing            []string       `json:"firing"`
+}
+
+// seriesDetail describes a tracked TS, with its baseline, rules and recent data
+type seriesDetail struct {
+	seriesSummary
+	// Mean and StdDev are set once Ready
+	Mean   *float64 `json:"mean,omitempty"`
+	StdDev *float64 `json:"stddev,omitempty"`
+	Stats  string   `json:"stats"`
+	// Buckets is the baseline of each seasonal bucket, Mean and StdDev are of the current
+	// bucket
+	Buckets []nelson.BucketStats `json:"buckets"`
+	Rules   []ruleDetail         `json:"rules"`
+	// Recent evaluated data points, newest first
+	Recent []nelson.Point `json:"recent"`
+	// History of recent violations, oldest first
+	History []nelson.Violation `json:"history"`
+}
+
+type ruleDetail struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	State       string `json:"state"`
+	Violations  int    `json:"violations"`
+}
+
+// alertSummary describes a pending or firing rule of a tracked TS
+type alertSummary struct {
+	ID          string       `json:"id"`
+	Expression  string       `json:"expression"`
+	Labels      model.Metric `json:"labels"`
+	Rule        string       `json:"rule"`
+	Description string       `json:"description"`
+	State       string       `json:"state"`
+}
+
+// response is the envelope of every API response, as in the Prometheus API
+type response struct {
+	Status string      `json:"status"`
+	Data   interface{} `json:"data,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// registerAPI registers the JSON API handlers. /api/v1/series lists the tracked TS,
+// /api/v1/series/<id> details a tracked TS and /api/v1/alerts lists the firing rules
+// (or pending, with state=pending). The lists are filtered by the optional expression
+// and match parameters. Matchers use PromQL syntax, e.g. match={job="api",instance=~"pod-.*"},
+// and may repeat.
+func registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/series", handleSeriesList)
+	mux.HandleFunc("/api/v1/series/", handleSeriesDetail)
+	mux.HandleFunc("/api/v1/alerts", handleAlerts)
+}
+
+func handleSeriesList(w http.ResponseWriter, r *http.Request) {
+	selected, err := selectSeries(r, false)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result := make([]seriesSummary, 0, len(selected))
+	for _, ser := range selected {
+		ser.Lock()
+		result = append(result, ser.summary())
+		ser.Unlock()
+	}
+	writeData(w, result)
+}
+
+func handleSeriesDetail(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/series/")
+	var found *series
+	nelsonMap.Range(
+		func(k interface{}, v interface{}) bool {
+			if seriesID(k.(string)) == id {
+				found = v.(*series)
+				return false
+			}
+			return true
+		})
+	if found == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Unknown TS [%s]", id))
+		return
+	}
+
+	found.Lock()
+	detail := found.detail()
+	found.Unlock()
+	writeData(w, detail)
+}
+
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	state := nelson.Firing
+	switch s := r.FormValue("state"); s {
+	case "", "firing":
+	case "pending":
+		state = nelson.Pending
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Unknown state [%s], valid states: [pending firing]", s))
+		return
+	}
+
+	selected, err := selectSeries(r, false)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result := []alertSummary{}
+	for _, ser := range selected {
+		ser.Lock()
+		for _, rule := range ser.data.Rules {
+			if ser.data.State(rule.Name) == state {
+				result = append(result, alertSummary{
+					ID:          seriesID(seriesKey(ser.expression, ser.metric)),
+					Expression:  ser.expression,
+					Labels:      ser.metric,
+					Rule:        rule.Name,
+					Description: rule.Description,
+					State:       state.String(),
+				})
+			}
+		}
+		ser.Unlock()
+	}
+	writeData(w, result)
+}
+
+// selectSeries returns the tracked TS selected by the expression and match parameters,
+// ordered by key. If required, an expression or at least one matcher must be set, so
+// every TS is never selected by omission.
+func selectSeries(r *http.Request, required bool) ([]*series, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	var ms []*matchers.Matcher
+	for _, match := range r.Form["match"] {
+		parsed, err := matchers.Parse(match)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, parsed...)
+	}
+	expression := r.Form.Get("expression")
+	if required && expression == "" && len(ms) == 0 {
+		return nil, errors.New("Expression or match must be set")
+	}
+
+	var keys []string
+	byKey := make(map[string]*series)
+	nelsonMap.Range(
+		func(k interface{}, v interface{}) bool {
+			ser := v.(*series)
+			if (expression == "" || ser.expression == expression) && matchers.MatchesAll(ms, ser.metric) {
+				keys = append(keys, k.(string))
+				byKey[k.(string)] = ser
+			}
+			return true
+		})
+	sort.Strings(keys)
+
+	result := make([]*series, len(keys))
+	for i, k := range keys {
+		result[i] = byKey[k]
+	}
+	return result, nil
+}
+
+// seriesID is a URL friendly identifier for the seriesKey
+func seriesID(key string) string {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// summary returns the seriesSummary, hold the lock
+func (ser *series) summary() seriesSummary {
+	d := ser.data
+	violations := make(map[string]int, len(d.Violations))
+	for k, v := range d.Violations {
+		violations[k] = v
+	}
+	firing := d.Firing()
+	if firing == nil {
+		firing = []string{}
+	}
+	return seriesSummary{
+		ID:                seriesID(seriesKey(ser.expression, ser.metric)),
+		Expression:        ser.expression,
+		Labels:            ser.metric,
+		Ready:             d.Ready(),
+		SamplesUntilReady: d.SamplesUntilReady(),
+		Stale:             ser.stale,
+		LastSeen:          ser.lastSeen,
+		LastTime:          d.LastTime(),
+		Violations:        violations,
+		Firing:            firing,
+	}
+}
+
+// detail returns the seriesDetail, hold the lock
+func (ser *series) detail() seriesDetail {
+	d := ser.data
+	detail := seriesDetail{
+		seriesSummary: ser.summary(),
+		Stats:         d.String(),
+		Buckets:       d.Buckets(),
+		History:       append([]nelson.Violation{}, ser.history...),
+	}
+	if d.Ready() {
+		mean, stddev := d.Mean(), d.StdDev()
+		detail.Mean, detail.StdDev = &mean, &stddev
+	}
+	for _, r := range d.Rules {
+		detail.Rules = append(detail.Rules, ruleDetail{
+			Name:        r.Name,
+			Description: r.Description,
+			State:       d.State(r.Name).String(),
+			Violations:  d.Violations[r.Name],
+		})
+	}
+	for _, s := range d.Recent(d.ViolationsData.Len()) {
+		detail.Recent = append(detail.Recent, nelson.Point{T: s.Time(), V: s.Val()})
+	}
+	return detail
+}
+
+// addHistory records the violation, discarding the oldest beyond historySize. Hold the lock.
+func (ser *series) addHistory(v nelson.Violation) {
+	ser.history = append(ser.history, v)
+	if len(ser.history) > historySize {
+		ser.history = ser.history[len(ser.history)-historySize:]
+	}
+}
+
+func writeData(w http.ResponseWriter, data interface{}) {
+	writeJSON(w, http.StatusOK, response{Status: "success", Data: data})
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, response{Status: "error", Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, r response) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		status = http.StatusInternalServerError
+		b, _ = json.Marshal(response{Status: "error", Error: err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
@@ -0,0 +1,122 @@
+// httpapi_test.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/jshaughn/outlier/nelson"
+	"github.com/jshaughn/outlier/scrape"
+)
+
+// testAPI tracks a firing TS x/{a="1"}, a TS x/{a="2"} without a baseline and a TS
+// y/{a="1"}, and returns a mux serving the JSON API
+func testAPI(t *testing.T) *http.ServeMux {
+	x := TSExpression{Expr: "x", SampleSize: 10}
+	assertEqual(t, nil, x.init(defaultOptions()))
+	y := testExpression(t, "y")
+	x.processSampleStream(firingStream(model.Metric{"a": "1"}, x.SampleSize), defaultOptions(), scrape.Scrape{})
+	x.processSampleStream(testStream(model.Metric{"a": "2"}, 1000), defaultOptions(), scrape.Scrape{})
+	y.processSampleStream(testStream(model.Metric{"a": "1"}, 1000), defaultOptions(), scrape.Scrape{})
+
+	mux := http.NewServeMux()
+	registerAPI(mux)
+	return mux
+}
+
+func TestSeriesList(t *testing.T) {
+	defer resetTracked()
+	_, restore := testSinks()
+	defer restore()
+	mux := testAPI(t)
+
+	list := func(url string) (keys []string) {
+		status, resp := serve(t, mux, http.MethodGet, url, "", "")
+		assertEqual(t, http.StatusOK, status)
+		var summaries []seriesSummary
+		assertEqual(t, nil, json.Unmarshal(resp.Data, &summaries))
+		for _, s := range summaries {
+			k := seriesKey(s.Expression, s.Labels)
+			assertEqual(t, seriesID(k), s.ID)
+			keys = append(keys, k)
+		}
+		return keys
+	}
+	assertEqual(t, `[x/{a="1"} x/{a="2"} y/{a="1"}]`, fmt.Sprint(list("/api/v1/series")))
+	assertEqual(t, `[x/{a="1"} x/{a="2"}]`, fmt.Sprint(list("/api/v1/series?expression=x")))
+	assertEqual(t, `[x/{a="1"} y/{a="1"}]`, fmt.Sprint(list(`/api/v1/series?match={a="1"}`)))
+	assertEqual(t, `[x/{a="1"}]`, fmt.Sprint(list(`/api/v1/series?expression=x&match={a=~"1|3"}`)))
+	assertEqual(t, `[]`, fmt.Sprint(list("/api/v1/series?expression=z")))
+
+	status, resp := serve(t, mux, http.MethodGet, "/api/v1/series?match={a=}", "", "")
+	assertEqual(t, http.StatusBadRequest, status)
+	assertEqual(t, "error", resp.Status)
+}
+
+func TestSeriesDetail(t *testing.T) {
+	defer resetTracked()
+	_, restore := testSinks()
+	defer restore()
+	mux := testAPI(t)
+
+	status, resp := serve(t, mux, http.MethodGet, "/api/v1/series/"+seriesID(`x/{a="1"}`), "", "")
+	assertEqual(t, http.StatusOK, status)
+	var detail seriesDetail
+	assertEqual(t, nil, json.Unmarshal(resp.Data, &detail))
+	assertEqual(t, "x", detail.Expression)
+	assertEqual(t, true, detail.Ready)
+	assertEqual(t, true, detail.Mean != nil && detail.StdDev != nil)
+	assertEqual(t, int64(11000), detail.LastTime)
+	assertEqual(t, 1, len(detail.Buckets))
+	assertEqual(t, true, len(detail.Firing) > 0)
+	assertEqual(t, true, len(detail.History) > 0)
+	assertEqual(t, true, len(detail.Recent) > 0)
+	assertEqual(t, 1000.0, detail.Recent[0].V)
+	for _, r := range detail.Rules {
+		if r.Name == detail.Firing[0] {
+			assertEqual(t, nelson.Firing.String(), r.State)
+			assertEqual(t, true, r.Violations > 0)
+		}
+	}
+
+	_, resp = serve(t, mux, http.MethodGet, "/api/v1/series/"+seriesID(`x/{a="2"}`), "", "")
+	detail = seriesDetail{}
+	assertEqual(t, nil, json.Unmarshal(resp.Data, &detail))
+	assertEqual(t, false, detail.Ready)
+	assertEqual(t, true, detail.Mean == nil)
+	assertEqual(t, 9, detail.SamplesUntilReady)
+
+	status, resp = serve(t, mux, http.MethodGet, "/api/v1/series/unknown", "", "")
+	assertEqual(t, http.StatusNotFound, status)
+	assertEqual(t, "Unknown TS [unknown]", resp.Error)
+}
+
+func TestAlerts(t *testing.T) {
+	defer resetTracked()
+	_, restore := testSinks()
+	defer restore()
+	mux := testAPI(t)
+
+	status, resp := serve(t, mux, http.MethodGet, "/api/v1/alerts", "", "")
+	assertEqual(t, http.StatusOK, status)
+	var alerts []alertSummary
+	assertEqual(t, nil, json.Unmarshal(resp.Data, &alerts))
+	assertEqual(t, true, len(alerts) > 0)
+	for _, a := range alerts {
+		assertEqual(t, seriesID(`x/{a="1"}`), a.ID)
+		assertEqual(t, nelson.Firing.String(), a.State)
+	}
+
+	_, resp = serve(t, mux, http.MethodGet, "/api/v1/alerts?expression=y", "", "")
+	assertEqual(t, "[]", string(resp.Data))
+	_, resp = serve(t, mux, http.MethodGet, "/api/v1/alerts?state=pending", "", "")
+	assertEqual(t, "[]", string(resp.Data))
+
+	status, resp = serve(t, mux, http.MethodGet, "/api/v1/alerts?state=resolved", "", "")
+	assertEqual(t, http.StatusBadRequest, status)
+	assertEqual(t, "Unknown state [resolved], valid states: [pending firing]", resp.Error)
+}
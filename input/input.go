@@ -0,0 +1,283 @@
+// input.go
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// Format is the encoding of offline time series data.
+type Format string
+
+const (
+	// CSV has a header row naming the columns. The "timestamp" (or "time") and "value"
+	// columns are required, the "metric" (or "__name__") column sets the metric name
+	// and every other column is a label.
+	CSV Format = "csv"
+	// JSONLines has one JSON object per line, either a single sample:
+	//   {"metric": {"__name__": "x", ...}, "timestamp": 1546300800, "value": 1.5}
+	// or a stream, as in a Prometheus range query result:
+	//   {"metric": {"__name__": "x", ...}, "values": [[1546300800, "1.5"], ...]}
+	JSONLines Format = "jsonl"
+	// PromText is the Prometheus text exposition format. Every sample must have a
+	// timestamp.
+	PromText Format = "prom"
+)
+
+var formats = []Format{CSV, JSONLines, PromText}
+
+// ParseFormat returns the Format for name, one of: csv, jsonl, prom.
+func ParseFormat(name string) (Format, error) {
+	for _, f := range formats {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown format [%s], valid formats: %v", name, formats)
+}
+
+// FormatFor returns the Format implied by the file extension of path.
+func FormatFor(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return CSV, nil
+	case ".jsonl", ".ndjson", ".json":
+		return JSONLines, nil
+	case ".prom", ".txt":
+		return PromText, nil
+	default:
+		return "", fmt.Errorf("Unable to determine format of [%s], valid formats: %v", path, formats)
+	}
+}
+
+// Read returns the time series encoded in r, one SampleStream per distinct metric in
+// order of first appearance. Sample values are sorted by time.
+func Read(r io.Reader, f Format) (model.Matrix, error) {
+	m := newMatrix()
+	var err error
+	switch f {
+	case CSV:
+		err = readCSV(r, m)
+	case JSONLines:
+		err = readJSONLines(r, m)
+	case PromText:
+		err = readPromText(r, m)
+	default:
+		err = fmt.Errorf("Unknown format [%s], valid formats: %v", f, formats)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.result(), nil
+}
+
+// matrix groups samples by metric
+type matrix struct {
+	streams map[model.Fingerprint]*model.SampleStream
+	order   []model.Fingerprint
+}
+
+func newMatrix() *matrix {
+	return &matrix{streams: make(map[model.Fingerprint]*model.SampleStream)}
+}
+
+func (m *matrix) add(metric model.Metric, values ...model.SamplePair) {
+	fp := metric.Fingerprint()
+	s, ok := m.streams[fp]
+	if !ok {
+		s = &model.SampleStream{Metric: metric}
+		m.streams[fp] = s
+		m.order = append(m.order, fp)
+	}
+	s.Values = append(s.Values, values...)
+}
+
+func (m *matrix) result() model.Matrix {
+	result := make(model.Matrix, len(m.order))
+	for i, fp := range m.order {
+		s := m.streams[fp]
+		sort.SliceStable(s.Values,
+			func(i, j int) bool {
+				return s.Values[i].Timestamp < s.Values[j].Timestamp
+			})
+		result[i] = s
+	}
+	return result
+}
+
+func readCSV(r io.Reader, m *matrix) error {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid CSV: %v", err)
+	}
+
+	timeCol, valueCol := -1, -1
+	labels := make([]model.LabelName, len(header))
+	for i, h := range header {
+		h = strings.TrimSpace(h)
+		switch h {
+		case "timestamp", "time":
+			timeCol = i
+		case "value":
+			valueCol = i
+		case "metric", model.MetricNameLabel:
+			labels[i] = model.MetricNameLabel
+		default:
+			if !model.LabelName(h).IsValid() {
+				return fmt.Errorf("Invalid CSV: column [%d] is not a valid label name [%s]", i+1, h)
+			}
+			labels[i] = model.LabelName(h)
+		}
+	}
+	if timeCol < 0 || valueCol < 0 {
+		return fmt.Errorf("Invalid CSV: header must name timestamp and value columns, got %v", header)
+	}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid CSV: %v", err)
+		}
+		line, _ := cr.FieldPos(0)
+
+		t, err := parseTime(record[timeCol])
+		if err != nil {
+			return fmt.Errorf("Invalid CSV: line [%d]: %v", line, err)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(record[valueCol]), 64)
+		if err != nil {
+			return fmt.Errorf("Invalid CSV: line [%d]: invalid value [%s]", line, record[valueCol])
+		}
+
+		metric := make(model.Metric, len(labels))
+		for i, l := range labels {
+			if l != "" && record[i] != "" {
+				metric[l] = model.LabelValue(record[i])
+			}
+		}
+		m.add(metric, model.SamplePair{Timestamp: t, Value: model.SampleValue(v)})
+	}
+}
+
+// jsonLine is a single sample or, if Values is set, a stream of samples
+type jsonLine struct {
+	Metric    model.Metric       `json:"metric"`
+	Timestamp json.RawMessage    `json:"timestamp"`
+	Value     json.RawMessage    `json:"value"`
+	Values    []model.SamplePair `json:"values"`
+}
+
+func readJSONLines(r io.Reader, m *matrix) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+
+		var jl jsonLine
+		if err := json.Unmarshal(b, &jl); err != nil {
+			return fmt.Errorf("Invalid JSON: line [%d]: %v", line, err)
+		}
+		if jl.Metric == nil {
+			jl.Metric = model.Metric{}
+		}
+		if jl.Values != nil {
+			m.add(jl.Metric, jl.Values...)
+			continue
+		}
+
+		if jl.Timestamp == nil || jl.Value == nil {
+			return fmt.Errorf("Invalid JSON: line [%d]: timestamp and value, or values, must be set", line)
+		}
+		t, err := parseTime(unquote(jl.Timestamp))
+		if err != nil {
+			return fmt.Errorf("Invalid JSON: line [%d]: %v", line, err)
+		}
+		v, err := strconv.ParseFloat(unquote(jl.Value), 64)
+		if err != nil {
+			return fmt.Errorf("Invalid JSON: line [%d]: invalid value [%s]", line, jl.Value)
+		}
+		m.add(jl.Metric, model.SamplePair{Timestamp: t, Value: model.SampleValue(v)})
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Invalid JSON: %v", err)
+	}
+	return nil
+}
+
+// unquote returns the raw JSON number, or the content of the JSON string
+func unquote(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+func readPromText(r io.Reader, m *matrix) error {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return fmt.Errorf("Invalid Prometheus text: %v", err)
+	}
+
+	// sort for a deterministic order, the parser returns a map
+	names := make([]string, 0, len(families))
+	for name, mf := range families {
+		for _, metric := range mf.Metric {
+			if metric.TimestampMs == nil {
+				return fmt.Errorf("Invalid Prometheus text: metric [%s] has a sample without a timestamp", name)
+			}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		vector, err := expfmt.ExtractSamples(&expfmt.DecodeOptions{}, families[name])
+		if err != nil {
+			return fmt.Errorf("Invalid Prometheus text: %v", err)
+		}
+		for _, s := range vector {
+			m.add(s.Metric, model.SamplePair{Timestamp: s.Timestamp, Value: s.Value})
+		}
+	}
+	return nil
+}
+
+// parseTime parses RFC3339 or unix time in (fractional) seconds
+func parseTime(s string) (model.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return model.TimeFromUnixNano(t.UnixNano()), nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp [%s], expected RFC3339 or unix seconds", s)
+	}
+	return model.TimeFromUnixNano(int64(secs * float64(time.Second))), nil
+}
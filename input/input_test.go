@@ -0,0 +1,114 @@
+// input_test.go
+package input
+
+import (
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestCSV(t *testing.T) {
+	in := `# exported response times
+timestamp,metric,instance,value
+1546300810,response_time,a,10.5
+1546300800,response_time,a,9.5
+2019-01-01T00:00:00Z,response_time,b,20
+`
+	m, err := Read(strings.NewReader(in), CSV)
+	assertEqual(t, true, err == nil)
+	assertEqual(t, 2, len(m))
+
+	assertEqual(t, `response_time{instance="a"}`, m[0].Metric.String())
+	assertEqual(t, 2, len(m[0].Values))
+	// sorted by time
+	assertEqual(t, model.Time(1546300800000), m[0].Values[0].Timestamp)
+	assertEqual(t, model.SampleValue(9.5), m[0].Values[0].Value)
+	assertEqual(t, model.Time(1546300810000), m[0].Values[1].Timestamp)
+
+	assertEqual(t, `response_time{instance="b"}`, m[1].Metric.String())
+	assertEqual(t, model.Time(1546300800000), m[1].Values[0].Timestamp)
+	assertEqual(t, model.SampleValue(20), m[1].Values[0].Value)
+
+	_, err = Read(strings.NewReader("time,instance\n1,a\n"), CSV)
+	assertEqual(t, "Invalid CSV: header must name timestamp and value columns, got [time instance]", fmt.Sprint(err))
+	_, err = Read(strings.NewReader("time,value\n1,a\n"), CSV)
+	assertEqual(t, "Invalid CSV: line [2]: invalid value [a]", fmt.Sprint(err))
+	_, err = Read(strings.NewReader("time,value\nyesterday,1\n"), CSV)
+	assertEqual(t, "Invalid CSV: line [2]: invalid timestamp [yesterday], expected RFC3339 or unix seconds", fmt.Sprint(err))
+}
+
+func TestJSONLines(t *testing.T) {
+	in := `{"metric": {"__name__": "response_time", "instance": "a"}, "timestamp": 1546300800.5, "value": 9.5}
+
+{"metric": {"__name__": "response_time", "instance": "a"}, "timestamp": "2019-01-01T00:00:10Z", "value": "10.5"}
+{"metric": {"__name__": "response_time", "instance": "b"}, "values": [[1546300810, "2"], [1546300800, "1"]]}
+`
+	m, err := Read(strings.NewReader(in), JSONLines)
+	assertEqual(t, true, err == nil)
+	assertEqual(t, 2, len(m))
+
+	assertEqual(t, `response_time{instance="a"}`, m[0].Metric.String())
+	assertEqual(t, 2, len(m[0].Values))
+	assertEqual(t, model.Time(1546300800500), m[0].Values[0].Timestamp)
+	assertEqual(t, model.SampleValue(9.5), m[0].Values[0].Value)
+	assertEqual(t, model.Time(1546300810000), m[0].Values[1].Timestamp)
+	assertEqual(t, model.SampleValue(10.5), m[0].Values[1].Value)
+
+	assertEqual(t, `response_time{instance="b"}`, m[1].Metric.String())
+	assertEqual(t, model.SampleValue(1), m[1].Values[0].Value)
+	assertEqual(t, model.SampleValue(2), m[1].Values[1].Value)
+
+	_, err = Read(strings.NewReader(`{"metric": {}, "value": 1}`), JSONLines)
+	assertEqual(t, "Invalid JSON: line [1]: timestamp and value, or values, must be set", fmt.Sprint(err))
+}
+
+func TestPromText(t *testing.T) {
+	in := `# TYPE response_time gauge
+response_time{instance="b"} 20 1546300800000
+response_time{instance="a"} 10.5 1546300810000
+response_time{instance="a"} 9.5 1546300800000
+`
+	m, err := Read(strings.NewReader(in), PromText)
+	assertEqual(t, true, err == nil)
+	assertEqual(t, 2, len(m))
+
+	assertEqual(t, `response_time{instance="b"}`, m[0].Metric.String())
+	assertEqual(t, `response_time{instance="a"}`, m[1].Metric.String())
+	assertEqual(t, 2, len(m[1].Values))
+	assertEqual(t, model.Time(1546300800000), m[1].Values[0].Timestamp)
+	assertEqual(t, model.SampleValue(9.5), m[1].Values[0].Value)
+
+	_, err = Read(strings.NewReader("response_time 1\n"), PromText)
+	assertEqual(t, "Invalid Prometheus text: metric [response_time] has a sample without a timestamp", fmt.Sprint(err))
+}
+
+func TestFormat(t *testing.T) {
+	f, err := FormatFor("data/export.CSV")
+	assertEqual(t, CSV, f)
+	f, err = FormatFor("export.ndjson")
+	assertEqual(t, JSONLines, f)
+	f, err = FormatFor("metrics.prom")
+	assertEqual(t, PromText, f)
+	_, err = FormatFor("export")
+	assertEqual(t, "Unable to determine format of [export], valid formats: [csv jsonl prom]", fmt.Sprint(err))
+
+	f, err = ParseFormat("jsonl")
+	assertEqual(t, JSONLines, f)
+	_, err = ParseFormat("xml")
+	assertEqual(t, "Unknown format [xml], valid formats: [csv jsonl prom]", fmt.Sprint(err))
+}
+
+func assertEqual(t *testing.T, e interface{}, v interface{}) {
+	if reflect.TypeOf(e) != reflect.TypeOf(v) {
+		debug.PrintStack()
+		t.Fatal(fmt.Sprintf("Expected |%v|, Got |%v|", reflect.TypeOf(e), reflect.TypeOf(v)))
+	}
+	if e != v {
+		debug.PrintStack()
+		t.Fatal(fmt.Sprintf("Expected |%v|, Got |%v|", e, v))
+	}
+}
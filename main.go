@@ -3,13 +3,14 @@ package main
 
 import (
 	"context"
-	"errors"
-	"flag"
 	"fmt"
+	"math/rand"
+	"net/http"
 	"os"
-	"sort"
-	"strconv"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/api"
@@ -18,129 +19,457 @@ import (
 
 	"github.com/jshaughn/outlier/nelson"
 	"github.com/jshaughn/outlier/scrape"
+	"github.com/jshaughn/outlier/simulate"
+	"github.com/jshaughn/outlier/sink"
 )
 
 type options struct {
-	server     string
-	sampleSize int
-	offset     time.Duration
-	interval   time.Duration
-	endpoint   string
+	server         string
+	sampleSize     int
+	offset         time.Duration
+	interval       time.Duration
+	endpoint       string
+	rules          string
+	baseline       nelson.Baseline
+	estimator      nelson.Estimator
+	trim           float64
+	seasonality    nelson.Seasonality
+	location       *time.Location
+	alertFor       time.Duration
+	resolveAfter   int
+	maxSeries      int
+	seriesTTL      int
+	adminToken     string
+	muteFile       string
+	stateFile      string
+	checkpoint     time.Duration
+	start          time.Time
+	end            time.Time
+	step           time.Duration
+	input          string
+	format         string
+	simulate       []simulate.Waveform
+	simMetric      string
+	simInterval    time.Duration
+	simPoints      int
+	simSeed        int64
+	config         string
+	reloadInterval time.Duration
+	queryTimeout   time.Duration
+	queryRetries   int
+	queryBackoff   time.Duration
 }
 
-func parseFlags() options {
-	serverDefault, ok := os.LookupEnv("PROMETHEUS_SERVER")
-	if !ok {
-		serverDefault = "http://localhost:9090"
-	}
-	server := flag.String("server", serverDefault, "Prometheus server URL (can be set via PROMETHEUS_SERVER environment variable)")
-	sampleSize := flag.String("sampleSize", "50", "Number of data points used to calculate mean, standard deviation, etc.")
-	offset := flag.String("offset", "0m", "Offset (Xm, Xh, or Xd) from now to start metric sample collection.")
-	interval := flag.String("interval", "30s", "Query interval (Xs). Recommended 2 times the scrape interval.")
-	endpoint := flag.String("endpoint", ":8080", "The scrape endpoint")
+// TSExpression is a watched PromQL expression. Each resulting TS is tracked separately.
+type TSExpression struct {
+	// Name uniquely identifies the expression, defaults to Expr
+	Name       string        `yaml:"name"`
+	Expr       string        `yaml:"expr"`
+	SampleSize int           `yaml:"sampleSize"`
+	Interval   time.Duration `yaml:"interval"`
+	// Offset delays each query, defaults to the offset option, 0s queries the current time
+	Offset *time.Duration `yaml:"offset"`
+	// Rules is the name of the rule set
+	Rules string `yaml:"rules"`
+	// Labels optionally filters the TS, only TS with all of the label values are tracked
+	Labels map[string]string `yaml:"labels"`
+	// Timeout limits each query attempt
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries is the number of times (at most maxRetries) a failed query is retried, with
+	// exponential backoff starting at Backoff, capped at Interval. Defaults to the
+	// queryRetries option, 0 disables retries.
+	Retries *int          `yaml:"retries"`
+	Backoff time.Duration `yaml:"backoff"`
+	// For is how long a rule must keep violating before it fires. Defaults to the alertFor
+	// option, 0s fires on the first violation.
+	For *time.Duration `yaml:"for"`
+	// ResolveAfter is the number of consecutive non-violating data points that resolve a
+	// firing rule
+	ResolveAfter int `yaml:"resolveAfter"`
+	// Instant queries Expr as-is each interval, tracking one data point per TS per interval.
+	// Otherwise a vector selector is queried as a range, and any other Expr as a subquery
+	// at Interval resolution, to pick up every data point, including late and missed ones.
+	// Defaults to false for a vector selector, true otherwise.
+	Instant *bool `yaml:"instant"`
+	rules   []nelson.Rule
+}
 
-	flag.Parse()
+// instant returns true if Expr is queried as an instant query, see Instant
+func (ts TSExpression) instant() bool {
+	return ts.Instant != nil && *ts.Instant
+}
 
-	return options{
-		server:     *server,
-		sampleSize: intOption(*sampleSize),
-		offset:     durationOption(*offset),
-		interval:   durationOption(*interval),
-		endpoint:   *endpoint,
+// retries returns the number of times a failed query is retried, see Retries
+func (ts TSExpression) retries() int {
+	if ts.Retries == nil {
+		return 0
 	}
+	return *ts.Retries
 }
 
-func intOption(option string) int {
-	val, err := strconv.Atoi(option)
-	checkError(err)
-	return val
+// offset returns how long each query is delayed, see Offset
+func (ts TSExpression) offset() time.Duration {
+	if ts.Offset == nil {
+		return 0
+	}
+	return *ts.Offset
 }
 
-func durationOption(option string) time.Duration {
-	val, err := time.ParseDuration(option)
-	checkError(err)
-	return val
+// alertFor returns how long a rule must keep violating before it fires, see For
+func (ts TSExpression) alertFor() time.Duration {
+	if ts.For == nil {
+		return 0
+	}
+	return *ts.For
 }
 
-func validateOptions(options options) error {
-	fmt.Printf("Options: %+v\n", options)
-
-	if options.sampleSize <= 0 {
-		return errors.New("SampleSize must be > 0")
+var (
+	// tsExpressions are watched when no config file is supplied
+	tsExpressions = []TSExpression{
+		{Expr: "response_time"},
 	}
-	if options.server == "" {
-		return errors.New("Server must be set")
+)
+
+// expressions returns the TSExpressions defined by the config file, or the defaults
+func expressions(o options) ([]TSExpression, error) {
+	if o.config != "" {
+		return loadConfig(o.config, o)
 	}
 
-	return nil
+	result := make([]TSExpression, len(tsExpressions))
+	copy(result, tsExpressions)
+	for i := range result {
+		if err := result[i].init(o); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
 }
 
-type TSExpression string
+// maxCatchUpIntervals limits the window of a catch-up query, in intervals
+const maxCatchUpIntervals = 120
+
+// process() is expected to execute as a goroutine, it returns when ctx is cancelled.
+// Each interval it queries the window since the previously processed query, plus one
+// interval of overlap to pick up late samples. Already consumed samples are skipped
+// per TS, so overlapping windows are not evaluated twice. If queries fail, or the loop
+// falls behind, the next query catches up on the missed window. TS already tracked, e.g.
+// restored from a checkpoint, are caught up from their newest consumed sample.
+func (ts TSExpression) process(ctx context.Context, o options, api v1.API, ep scrape.Scrape) {
+	// queryTime of the most recently processed query, zero until a query succeeds unless TS
+	// are already tracked
+	processed := ts.lastConsumed()
+	next := time.Now()
 
-var (
-	tsExpressions = []TSExpression{
-		"response_time",
+	for {
+		queryTime := time.Now().Add(-ts.offset())
+		query := ts.nextQuery(queryTime, processed)
+		if ts.query(ctx, query, queryTime, o, api, ep) {
+			processed = queryTime
+		}
+
+		// schedule from the previous tick, not the end of the query, to avoid drift
+		next = next.Add(ts.Interval)
+		wait := time.Until(next)
+		if wait < 0 {
+			next = time.Now()
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
 	}
-)
+}
 
-// process() is expected to execute as a goroutine
-func (ts TSExpression) process(o options, wg sync.WaitGroup, api v1.API, ep scrape.Scrape) {
-	defer wg.Done()
+// nextQuery returns the query to execute at queryTime, given the queryTime of the most
+// recently processed query (zero if none)
+func (ts TSExpression) nextQuery(queryTime, processed time.Time) string {
+	if ts.instant() {
+		// an instant query evaluates only queryTime, missed intervals can't be caught up
+		if gap := queryTime.Sub(processed); !processed.IsZero() && gap > 2*ts.Interval {
+			fmt.Printf("Expression [%s] is an instant query, data points are skipped for %v since last processed query\n", ts.Name, gap-ts.Interval)
+		}
+		return ts.Expr
+	}
 
-	queryTime := time.Now()
-	if o.offset.Seconds() > 0 {
-		queryTime = queryTime.Add(-o.offset)
+	window := ts.Interval
+	if !processed.IsZero() {
+		gap := queryTime.Sub(processed)
+		if gap > 2*ts.Interval {
+			fmt.Printf("Catching up expression [%s], %v since last processed query\n", ts.Name, gap)
+		}
+		if max := maxCatchUpIntervals * ts.Interval; gap > max {
+			fmt.Printf("Catch-up for expression [%s] limited to %v, samples are skipped\n", ts.Name, max)
+			gap = max
+		}
+		window = gap + ts.Interval
+	}
+	// the range precedes any offset or @ modifier of a selector
+	if m := selector.FindStringSubmatch(ts.Expr); m != nil {
+		return fmt.Sprintf("%v [%v]%v", strings.TrimSpace(m[1]), promDuration(window), m[2])
 	}
+	return fmt.Sprintf("(%v)[%v:%v]", ts.Expr, promDuration(window), promDuration(ts.Interval))
+}
 
-	query := fmt.Sprintf("%v [%v]", ts, o.interval)
+// lastConsumed returns the time of the newest sample consumed by the TS tracked for ts, or
+// zero if none
+func (ts TSExpression) lastConsumed() (last time.Time) {
+	nelsonMap.Range(
+		func(k interface{}, v interface{}) bool {
+			ser := v.(*series)
+			if ser.expression == ts.Name {
+				ser.Lock()
+				lastTime := ser.data.LastTime()
+				ser.Unlock()
+				if t := model.Time(lastTime).Time(); lastTime > 0 && t.After(last) {
+					last = t
+				}
+			}
+			return true
+		})
+	return last
+}
 
-	for {
-		ts.query(query, queryTime, o, api, ep)
-		time.Sleep(o.interval)
-		queryTime = queryTime.Add(o.interval)
+// promDuration formats d as a PromQL range duration, in whole seconds rounded up
+func promDuration(d time.Duration) string {
+	seconds := int64((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
 	}
+	return fmt.Sprintf("%ds", seconds)
 }
 
 // TF is the TimeFormat for printing timestamp
 const TF = "2006-01-02 15:04:05"
 
-func (ts TSExpression) query(query string, queryTime time.Time, o options, api v1.API, ep scrape.Scrape) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
+// query returns true if the query succeeded and its results were processed
+func (ts TSExpression) query(ctx context.Context, query string, queryTime time.Time, o options, api v1.API, ep scrape.Scrape) bool {
 	fmt.Printf("Executing query %s @%s (now=%v)\n", query, queryTime.Format(TF), time.Now().Format(TF))
 
-	value, err := api.Query(ctx, query, queryTime)
-	checkError(err)
+	value, err := ts.withRetry(ctx, query, ep,
+		func(ctx context.Context) (model.Value, error) {
+			return api.Query(ctx, query, queryTime)
+		})
+	if ctx.Err() != nil {
+		// cancelled, shutting down or stopping the watcher
+		return false
+	}
+	if err != nil {
+		fmt.Printf("Query %s failed after [%v] retries, marking TS stale: %v\n", query, ts.retries(), err)
+		ep.QueryFailed(ts.Name)
+		ts.markStale(ep)
+		return false
+	}
 
+	var matrix model.Matrix
 	switch t := value.Type(); t {
-	case model.ValVector: // Instant Vector
-		fmt.Printf("Handle Instant Vector\n")
-		vector := value.(model.Vector)
-		for _, sample := range vector {
-			fmt.Printf("sample: %v\n", sample)
+	case model.ValVector: // Instant Vector, a data point for each TS
+		for _, s := range value.(model.Vector) {
+			matrix = append(matrix, &model.SampleStream{
+				Metric: s.Metric,
+				Values: []model.SamplePair{{Timestamp: s.Timestamp, Value: s.Value}},
+			})
 		}
+	case model.ValScalar: // a data point, tracked as a TS without labels
+		s := value.(*model.Scalar)
+		matrix = model.Matrix{{
+			Metric: model.Metric{},
+			Values: []model.SamplePair{{Timestamp: s.Timestamp, Value: s.Value}},
+		}}
 	case model.ValMatrix: // Range Vector
-		matrix := value.(model.Matrix)
-		//fmt.Printf("Handle Range Vector, matrix len=%v\n", len(matrix))
-		for _, s := range matrix {
-			processSampleStream(s, o, ep)
-		}
+		matrix = value.(model.Matrix)
 	default:
 		fmt.Printf("No handling for type %v!\n", t)
+		return true
+	}
+
+	var newest model.Time
+	for _, s := range matrix {
+		if ts.matches(s.Metric) {
+			ts.processSampleStream(s, o, ep)
+			if n := len(s.Values); n > 0 && s.Values[n-1].Timestamp > newest {
+				newest = s.Values[n-1].Timestamp
+			}
+		}
 	}
+	if newest > 0 {
+		ep.SetLag(ts.Name, time.Since(newest.Time()))
+	}
+	ts.evictExpired(o, ep)
+	ep.SetTracked(ts.Name, ts.tracked())
+
+	return true
 }
 
-func checkError(err error) {
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+// maxRetries limits TSExpression.Retries, retries beyond a few intervals only delay the
+// next query
+const maxRetries = 10
+
+// retryBackoff returns the backoff before retry n (from 1). It starts at ts.Backoff and
+// doubles for each retry, capped at ts.Interval so a failing query does not block the
+// watcher for many intervals.
+func (ts TSExpression) retryBackoff(n int) time.Duration {
+	backoff := ts.Backoff
+	for i := 1; i < n && backoff < ts.Interval; i++ {
+		backoff *= 2
+	}
+	if backoff > ts.Interval {
+		backoff = ts.Interval
 	}
+	return backoff
 }
 
-// nelsonMap is concurrent key=metric string, value=*nelson.Data
+// withRetry executes the query function, retrying failed attempts with exponential backoff
+// and jitter. Each attempt is limited to ts.Timeout.
+func (ts TSExpression) withRetry(ctx context.Context, query string, ep scrape.Scrape, f func(ctx context.Context) (model.Value, error)) (model.Value, error) {
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, ts.Timeout)
+		start := time.Now()
+		value, err := f(attemptCtx)
+		ep.ObserveQuery(ts.Name, time.Since(start))
+		cancel()
+		if err == nil {
+			return value, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		ep.QueryError(ts.Name)
+		if attempt >= ts.retries() {
+			return nil, err
+		}
+
+		// equal jitter, wait between backoff/2 and backoff
+		backoff := ts.retryBackoff(attempt + 1)
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		fmt.Printf("Query %s failed, retry in %v: %v\n", query, wait, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// nelsonMap is concurrent key=seriesKey, value=*series
 var nelsonMap sync.Map
 
+// sinks receive the alert state transitions of every tracked TS, set at startup
+var sinks = sink.Sinks{sink.Log{}}
+
+// newEvent returns the sink Event for transition t of TS m
+func newEvent(expression string, m model.Metric, t nelson.Transition) sink.Event {
+	labels := make(map[string]string, len(m))
+	for k, lv := range m {
+		labels[string(k)] = string(lv)
+	}
+	return sink.Event{
+		Expression: expression,
+		Labels:     labels,
+		Rule:       t.Rule,
+		From:       t.From.String(),
+		State:      t.To.String(),
+		Time:       t.Time,
+		Violation:  t.Violation,
+	}
+}
+
+// send delivers the events to the sinks. Sinks may block on HTTP calls, so send must not
+// be called holding a series lock or trackLock.
+func send(events []sink.Event) {
+	for _, e := range events {
+		sinks.Send(e)
+	}
+}
+
+// series is a TS tracked for a TSExpression. nelson.Data is not safe for concurrent use,
+// hold the lock when accessing data.
+type series struct {
+	sync.Mutex
+	expression string
+	metric     model.Metric
+	data       *nelson.Data
+	// stale is true if the most recent query for the TSExpression failed
+	stale bool
+	// lastSeen is when the TS was last returned by a query
+	lastSeen time.Time
+	// history of recent violations, oldest first
+	history []nelson.Violation
+	// muted holds the pending and firing transitions suppressed by a mute, key=rule name,
+	// until they are delivered. From is the state last sent to the sinks.
+	muted map[string]nelson.Transition
+}
+
+// markStale flags the TS tracked for ts as stale, until they are next updated
+func (ts TSExpression) markStale(ep scrape.Scrape) {
+	nelsonMap.Range(
+		func(k interface{}, v interface{}) bool {
+			ser := v.(*series)
+			if ser.expression == ts.Name {
+				ser.Lock()
+				ser.stale = true
+				ser.Unlock()
+				ep.SetStale(ts.Name, ser.metric.String(), true)
+			}
+			return true
+		})
+}
+
+// tracked returns the number of TS tracked for ts
+func (ts TSExpression) tracked() (n int) {
+	nelsonMap.Range(
+		func(k interface{}, v interface{}) bool {
+			if v.(*series).expression == ts.Name {
+				n++
+			}
+			return true
+		})
+	return n
+}
+
+// stats returns the detector state of the series, hold the lock
+func (ser *series) stats() scrape.SeriesStats {
+	d := ser.data
+	stats := scrape.SeriesStats{
+		Ready:             d.Ready(),
+		Mean:              d.Mean(),
+		StdDev:            d.StdDev(),
+		SamplesUntilReady: d.SamplesUntilReady(),
+		Violating:         make(map[string]bool, len(d.Rules)),
+	}
+	if recent := d.Recent(1); len(recent) > 0 {
+		stats.Evaluated = true
+		stats.LastValue = recent[0].Val()
+	}
+	for _, r := range d.Rules {
+		state := d.State(r.Name)
+		stats.Violating[r.Name] = state == nelson.Pending || state == nelson.Firing
+	}
+	return stats
+}
+
+// seriesKey uniquely identifies a TS across TSExpressions
+func seriesKey(expression string, m model.Metric) string {
+	return expression + "/" + m.String()
+}
+
+func newSeries(m model.Metric, ts TSExpression, o options) *series {
+	d := nelson.NewDataWithOptions(m, nelson.Options{
+		SampleSize:  ts.SampleSize,
+		Rules:       ts.rules,
+		Baseline:    o.baseline,
+		Estimator:   o.estimator,
+		Trim:        o.trim,
+		Seasonality: o.seasonality,
+		Location:    o.location,
+		Alert:       nelson.AlertOptions{For: ts.alertFor(), ResolveAfter: ts.ResolveAfter},
+	})
+	return &series{expression: ts.Name, metric: m, data: &d, lastSeen: time.Now()}
+}
+
 type SamplePair model.SamplePair
 
 // Time() returns ms since epoch (i.e. unix timestamp)
@@ -152,74 +481,199 @@ func (sp SamplePair) Val() float64 {
 	return float64(sp.Value)
 }
 
-func toSamplePairs(in []model.SamplePair, sorted bool) (out []nelson.Sample) {
+func toSamplePairs(in []model.SamplePair) (out []nelson.Sample) {
 	out = make([]nelson.Sample, len(in))
 	for i, v := range in {
 		out[i] = SamplePair(v)
 	}
-
-	// sort by time ascending (process oldest first)
-	if sorted {
-		sort.Slice(out,
-			func(i, j int) bool {
-				return out[i].Time() < out[j].Time()
-			})
-	}
-
 	return out
 }
 
-func processSampleStream(s *model.SampleStream, o options, ep scrape.Scrape) {
+func (ts TSExpression) processSampleStream(s *model.SampleStream, o options, ep scrape.Scrape) {
 	//nelsonMap.Range(
 	//	func(k interface{}, v interface{}) bool {
 	//		fmt.Println("MapKey:", k)
 	//		return true
 	//	})
 
-	k := s.Metric.String()
-	result, ok := nelsonMap.Load(k)
-	var d *nelson.Data
-	if !ok {
-		fmt.Println("Start tracking TS ", k)
-		ds := nelson.NewData(s.Metric, o.sampleSize, nelson.CommonRules...)
-		d = &ds
-		nelsonMap.Store(k, d)
+	k := seriesKey(ts.Name, s.Metric)
+	var ser *series
+	var events []sink.Event
+	if result, ok := nelsonMap.Load(k); ok {
+		ser = result.(*series)
 	} else {
-		d = result.(*nelson.Data)
+		ser, events = track(k, newSeries(s.Metric, ts, o), o, ep)
+	}
+	ser.Lock()
+	if !tracked(k, ser) {
+		// evicted since it was looked up, setting its metrics would leak them
+		ser.Unlock()
+		fmt.Printf("Discarded samples of evicted TS %s\n", k)
+		send(events)
+		return
 	}
+	ser.lastSeen = time.Now()
 
-	for _, sp := range toSamplePairs(s.Values, true) {
-		violations := d.AddSample(sp)
-		for k, v := range violations {
-			if v {
-				fmt.Printf("Add Violation! %s %v\n", k, s.Metric)
-				ep.Add(k, s.Metric.String(), 1)
-			}
+	if ser.stale {
+		ser.stale = false
+		ep.SetStale(ts.Name, s.Metric.String(), false)
+	}
 
+	// skip samples already consumed by an overlapping query, or arriving out of order. The
+	// overlap is expected, only samples older than the overlap are reported.
+	last := ser.data.LastTime()
+	overlap := last - int64(ts.Interval/time.Millisecond)
+	values := make([]model.SamplePair, 0, len(s.Values))
+	outOfOrder := 0
+	for _, v := range s.Values {
+		if int64(v.Timestamp) > last {
+			values = append(values, v)
+		} else if int64(v.Timestamp) < overlap {
+			outOfOrder++
 		}
 	}
-	fmt.Printf("Data: %+v\n", d)
+	if outOfOrder > 0 {
+		fmt.Printf("Skipped [%v] out of order samples for TS %s\n", outOfOrder, k)
+	}
+
+	// AddSamples processes oldest first
+	var transitions []nelson.Transition
+	for _, r := range ser.data.AddSamples(toSamplePairs(values)) {
+		for _, v := range r.Violations {
+			ep.Add(v.Rule, s.Metric.String(), 1)
+			ser.addHistory(v)
+		}
+		transitions = append(transitions, r.Transitions...)
+	}
+	events = append(events, ser.deliver(transitions)...)
+	ep.SetSeries(ts.Name, s.Metric.String(), ser.stats())
+	ser.Unlock()
+
+	send(events)
 }
 
 func main() {
-	options := parseFlags()
-	checkError(validateOptions(options))
+	rand.Seed(time.Now().UnixNano())
+	os.Exit(runCLI(os.Args[1:], os.Stderr))
+}
 
-	ep := scrape.Scrape{options.endpoint}
-	go ep.Start()
+// daemon watches the TSExpressions until SIGINT or SIGTERM. It returns an error if
+// startup fails, or if the scrape endpoint or final checkpoint fail.
+func daemon(options options) error {
+	printed := options
+	if printed.adminToken != "" {
+		printed.adminToken = "<redacted>"
+	}
+	fmt.Printf("Options: %+v\n", printed)
 
-	config := api.Config{options.server, nil}
+	watched, err := expressions(options)
+	if err != nil {
+		return err
+	}
+
+	// ctx is cancelled on shutdown, stopping the watchers, checkpoints and scrape endpoint
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if sinks, err = loadSinks(options.config); err != nil {
+		return err
+	}
+	sinks.Start(ctx)
+
+	if options.stateFile != "" {
+		if err = loadCheckpoint(options.stateFile, watched, options); err != nil {
+			return err
+		}
+		go checkpoint(ctx, options)
+	}
+
+	if len(options.simulate) > 0 {
+		sim := simulate.New("response_time", options.simInterval, options.simulate, simulate.Params{})
+		go sim.Run(ctx)
+	}
+
+	if err = mutes.load(options.muteFile); err != nil {
+		return err
+	}
+
+	ep := scrape.Scrape{Endpoint: options.endpoint}
+
+	// the JSON API is served with the metrics
+	registerAPI(http.DefaultServeMux)
+	if options.adminToken != "" {
+		registerAdmin(http.DefaultServeMux, options.adminToken, ep)
+	}
+
+	config := api.Config{Address: options.server}
 	client, err := api.NewClient(config)
-	checkError(err)
+	if err != nil {
+		return err
+	}
+
+	epDone := make(chan error, 1)
+	go func() {
+		epDone <- ep.Start(ctx)
+	}()
 
 	api := v1.NewAPI(client)
 
-	var wg sync.WaitGroup
+	w := newWatchers(ctx, options, api, ep)
+	w.apply(watched)
 
-	for _, ts := range tsExpressions {
-		wg.Add(1)
-		go ts.process(options, wg, api, ep)
+	// shutdown on SIGINT or SIGTERM
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGINT, syscall.SIGTERM)
+
+	// reload the config on SIGHUP or, optionally, when the config file changes
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	reload := make(chan struct{}, 1)
+	if options.config != "" && options.reloadInterval > 0 {
+		go watchConfig(ctx, options.config, options.reloadInterval, reload)
+	}
+
+	// result is the first failure, if any
+	var result error
+	running := true
+	for running {
+		select {
+		case sig := <-term:
+			fmt.Printf("Received %v, shutting down\n", sig)
+			running = false
+			continue
+		case err = <-epDone:
+			fmt.Printf("Scrape endpoint failed, shutting down: %v\n", err)
+			result = fmt.Errorf("Scrape endpoint failed: %v", err)
+			epDone = nil
+			running = false
+			continue
+		case <-hup:
+			fmt.Println("Received SIGHUP, reloading config")
+		case <-reload:
+			fmt.Println("Config file changed, reloading config")
+		}
+
+		watched, err = expressions(options)
+		if err != nil {
+			fmt.Printf("Reload failed, keeping current config: %v\n", err)
+			continue
+		}
+		w.apply(watched)
+	}
+
+	cancel()
+	w.stopAll()
+	if epDone != nil {
+		if err = <-epDone; err != nil && result == nil {
+			result = fmt.Errorf("Scrape endpoint shutdown failed: %v", err)
+		}
+	}
+	if options.stateFile != "" {
+		if err = saveCheckpoint(options.stateFile); err != nil && result == nil {
+			result = fmt.Errorf("Final checkpoint failed: %v", err)
+		}
 	}
 
-	wg.Wait()
+	fmt.Println("Shutdown complete")
+	return result
 }
@@ -0,0 +1,301 @@
+// main_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	"github.com/jshaughn/outlier/scrape"
+	"github.com/jshaughn/outlier/sink"
+)
+
+// the backoff doubles from Backoff, up to the Interval
+func TestRetryBackoff(t *testing.T) {
+	ts := TSExpression{Backoff: time.Second, Interval: 10 * time.Second}
+	var backoffs []time.Duration
+	for n := 1; n <= 6; n++ {
+		backoffs = append(backoffs, ts.retryBackoff(n))
+	}
+	assertEqual(t, "[1s 2s 4s 8s 10s 10s]", fmt.Sprint(backoffs))
+
+	// the cap prevents overflow
+	assertEqual(t, 10*time.Second, ts.retryBackoff(1000))
+
+	ts.Backoff = time.Minute
+	assertEqual(t, 10*time.Second, ts.retryBackoff(1))
+
+	retries := maxRetries + 1
+	ts = TSExpression{Expr: "x", Retries: &retries}
+	assertEqual(t, true, ts.init(defaultOptions()) != nil)
+}
+
+func TestRetry(t *testing.T) {
+	retries := 2
+	ts := TSExpression{Name: "test", Retries: &retries, Backoff: time.Millisecond, Interval: time.Millisecond, Timeout: time.Second}
+	attempts := 0
+	value, err := ts.withRetry(context.Background(), "q", scrape.Scrape{},
+		func(ctx context.Context) (model.Value, error) {
+			attempts++
+			if attempts <= 2 {
+				return nil, errors.New("failed")
+			}
+			return &model.Scalar{Value: 1}, nil
+		})
+	assertEqual(t, nil, err)
+	assertEqual(t, 3, attempts)
+	assertEqual(t, model.SampleValue(1), value.(*model.Scalar).Value)
+
+	attempts = 0
+	_, err = ts.withRetry(context.Background(), "q", scrape.Scrape{},
+		func(ctx context.Context) (model.Value, error) {
+			attempts++
+			return nil, errors.New("failed")
+		})
+	assertEqual(t, "failed", err.Error())
+	assertEqual(t, 3, attempts)
+}
+
+// cancellation interrupts the wait between attempts
+func TestRetryCancel(t *testing.T) {
+	retries := 5
+	ts := TSExpression{Name: "test", Retries: &retries, Backoff: time.Hour, Interval: time.Hour, Timeout: time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	failed := make(chan struct{})
+	go func() {
+		<-failed
+		cancel()
+	}()
+
+	attempts := 0
+	_, err := ts.withRetry(ctx, "q", scrape.Scrape{},
+		func(ctx context.Context) (model.Value, error) {
+			attempts++
+			close(failed)
+			return nil, errors.New("failed")
+		})
+	assertEqual(t, context.Canceled, err)
+	assertEqual(t, 1, attempts)
+}
+
+// the window covers the gap since the processed query, plus an interval of overlap, up to
+// maxCatchUpIntervals
+func TestNextQuery(t *testing.T) {
+	ts := TSExpression{Name: "test", Expr: "x", Interval: 30 * time.Second}
+	now := time.Now()
+	assertEqual(t, "x [30s]", ts.nextQuery(now, time.Time{}))
+	assertEqual(t, "x [60s]", ts.nextQuery(now, now.Add(-30*time.Second)))
+	assertEqual(t, "x [331s]", ts.nextQuery(now, now.Add(-300500*time.Millisecond)))
+	assertEqual(t, fmt.Sprintf("x [%ds]", (maxCatchUpIntervals+1)*30), ts.nextQuery(now, now.Add(-24*time.Hour)))
+
+	// modifiers follow the range
+	ts.Expr = `x{a="b"} offset 5m @ end()`
+	assertEqual(t, `x{a="b"} [30s] offset 5m @ end()`, ts.nextQuery(now, time.Time{}))
+
+	// other expressions are queried as a subquery, unless instant
+	ts.Expr = "avg(x)"
+	assertEqual(t, "(avg(x))[30s:30s]", ts.nextQuery(now, time.Time{}))
+	assertEqual(t, "(avg(x))[60s:30s]", ts.nextQuery(now, now.Add(-30*time.Second)))
+
+	instant := true
+	ts.Instant = &instant
+	assertEqual(t, "avg(x)", ts.nextQuery(now, time.Time{}))
+	assertEqual(t, "avg(x)", ts.nextQuery(now, now.Add(-time.Hour)))
+}
+
+// already tracked TS, e.g. restored from a checkpoint, are caught up from their newest sample
+func TestLastConsumed(t *testing.T) {
+	defer resetTracked()
+	ts := testExpression(t, "x")
+	assertEqual(t, true, ts.lastConsumed().IsZero())
+
+	now := model.Now()
+	ts.processSampleStream(testStream(model.Metric{"a": "1"}, now.Add(-time.Minute)), defaultOptions(), scrape.Scrape{})
+	ts.processSampleStream(testStream(model.Metric{"a": "2"}, now.Add(-2*time.Minute)), defaultOptions(), scrape.Scrape{})
+	other := testExpression(t, "y")
+	other.processSampleStream(testStream(model.Metric{"a": "1"}, now), defaultOptions(), scrape.Scrape{})
+
+	assertEqual(t, now.Add(-time.Minute).Time(), ts.lastConsumed())
+	assertEqual(t, "x [90s]", ts.nextQuery(now.Time(), ts.lastConsumed()))
+}
+
+// samples consumed by an overlapping query are skipped
+func TestProcessSampleStreamOverlap(t *testing.T) {
+	defer resetTracked()
+	ts := testExpression(t, "x")
+	m := model.Metric{"a": "1"}
+	ts.processSampleStream(testStream(m, 1000, 2000, 3000, 4000, 5000), defaultOptions(), scrape.Scrape{})
+	ts.processSampleStream(testStream(m, 3000, 4000, 5000, 6000, 7000, 8000), defaultOptions(), scrape.Scrape{})
+
+	v, ok := nelsonMap.Load(seriesKey("x", m))
+	assertEqual(t, true, ok)
+	ser := v.(*series)
+	assertEqual(t, int64(8000), ser.data.LastTime())
+	assertEqual(t, ts.SampleSize-8, ser.data.SamplesUntilReady())
+}
+
+// vector and scalar results are tracked as a data point per TS, matrix results as is
+func TestQuery(t *testing.T) {
+	defer resetTracked()
+	_, restore := testSinks()
+	defer restore()
+	now := model.Now()
+	var result model.Value
+	var err error
+	var queried []string
+	api := fakeAPI{query: func(query string, ts time.Time) (model.Value, error) {
+		queried = append(queried, query)
+		return result, err
+	}}
+	last := func(ts TSExpression, m model.Metric) int64 {
+		v, ok := nelsonMap.Load(seriesKey(ts.Name, m))
+		assertEqual(t, true, ok)
+		return v.(*series).data.LastTime()
+	}
+
+	instant := testExpression(t, "avg(x)")
+	result = model.Vector{
+		{Metric: model.Metric{"a": "1"}, Timestamp: now, Value: 1},
+		{Metric: model.Metric{"a": "2"}, Timestamp: now, Value: 2},
+	}
+	assertEqual(t, true, instant.query(context.Background(), "avg(x)", now.Time(), defaultOptions(), api, scrape.Scrape{}))
+	assertEqual(t, 2, instant.tracked())
+	assertEqual(t, int64(now), last(instant, model.Metric{"a": "2"}))
+
+	scalar := testExpression(t, "scalar(x)")
+	result = &model.Scalar{Timestamp: now, Value: 1}
+	assertEqual(t, true, scalar.query(context.Background(), "scalar(x)", now.Time(), defaultOptions(), api, scrape.Scrape{}))
+	assertEqual(t, 1, scalar.tracked())
+	assertEqual(t, int64(now), last(scalar, model.Metric{}))
+
+	// only the TS with the label values are tracked
+	rng := TSExpression{Expr: "x", Labels: map[string]string{"a": "1"}}
+	assertEqual(t, nil, rng.init(defaultOptions()))
+	result = model.Matrix{
+		testStream(model.Metric{"a": "1"}, now.Add(-time.Second), now),
+		testStream(model.Metric{"a": "2"}, now),
+	}
+	assertEqual(t, true, rng.query(context.Background(), "x [30s]", now.Time(), defaultOptions(), api, scrape.Scrape{}))
+	assertEqual(t, 1, rng.tracked())
+	assertEqual(t, int64(now), last(rng, model.Metric{"a": "1"}))
+
+	result = &model.String{Timestamp: now, Value: "x"}
+	assertEqual(t, true, rng.query(context.Background(), "x [30s]", now.Time(), defaultOptions(), api, scrape.Scrape{}))
+	assertEqual(t, 1, rng.tracked())
+	assertEqual(t, "[avg(x) scalar(x) x [30s] x [30s]]", fmt.Sprint(queried))
+
+	// a failed query marks the TS stale
+	noRetries := 0
+	rng.Retries = &noRetries
+	result, err = nil, errors.New("failed")
+	assertEqual(t, false, rng.query(context.Background(), "x [30s]", now.Time(), defaultOptions(), api, scrape.Scrape{}))
+	v, _ := nelsonMap.Load(seriesKey("x", model.Metric{"a": "1"}))
+	assertEqual(t, true, v.(*series).stale)
+	v, _ = nelsonMap.Load(seriesKey("avg(x)", model.Metric{"a": "1"}))
+	assertEqual(t, false, v.(*series).stale)
+}
+
+// fakeAPI answers queries with its functions, the other v1.API methods are not implemented
+type fakeAPI struct {
+	v1.API
+	query      func(query string, ts time.Time) (model.Value, error)
+	queryRange func(query string, r v1.Range) (model.Value, error)
+}
+
+func (f fakeAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, error) {
+	return f.query(query, ts)
+}
+
+func (f fakeAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, error) {
+	return f.queryRange(query, r)
+}
+
+// testExpression returns an initialized TSExpression named for expr
+func testExpression(t *testing.T, expr string) TSExpression {
+	ts := TSExpression{Expr: expr}
+	assertEqual(t, nil, ts.init(defaultOptions()))
+	return ts
+}
+
+// testStream returns a SampleStream for m with a value of 1 at each time
+func testStream(m model.Metric, times ...model.Time) *model.SampleStream {
+	s := &model.SampleStream{Metric: m}
+	for _, t := range times {
+		s.Values = append(s.Values, model.SamplePair{Timestamp: t, Value: 1})
+	}
+	return s
+}
+
+// recordSink records the events it is sent
+type recordSink struct {
+	events []sink.Event
+}
+
+func (r *recordSink) Send(e sink.Event) error {
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recordSink) String() string {
+	return "record"
+}
+
+// testSinks replaces the sinks with a recordSink, returning it and a function restoring them
+func testSinks() (*recordSink, func()) {
+	saved := sinks
+	r := &recordSink{}
+	sinks = sink.Sinks{r}
+	return r, func() { sinks = saved }
+}
+
+// apiResponse is a response of the JSON API, with its data left encoded
+type apiResponse struct {
+	Status string          `json:"status"`
+	Data   json.RawMessage `json:"data"`
+	Error  string          `json:"error"`
+}
+
+// serve sends the request to h, with the bearer token if set, and decodes the response
+func serve(t *testing.T, h http.Handler, method, url, token, body string) (int, apiResponse) {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	var resp apiResponse
+	assertEqual(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	return w.Code, resp
+}
+
+// resetTracked discards every tracked TS
+func resetTracked() {
+	nelsonMap.Range(
+		func(k interface{}, v interface{}) bool {
+			nelsonMap.Delete(k)
+			return true
+		})
+}
+
+func assertEqual(t *testing.T, e interface{}, v interface{}) {
+	if reflect.TypeOf(e) != reflect.TypeOf(v) {
+		debug.PrintStack()
+		t.Fatal(fmt.Sprintf("Expected |%v|, Got |%v|", reflect.TypeOf(e), reflect.TypeOf(v)))
+	}
+	if e != v {
+		debug.PrintStack()
+		t.Fatal(fmt.Sprintf("Expected |%v|, Got |%v|", e, v))
+	}
+}
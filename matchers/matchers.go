@@ -0,0 +1,176 @@
+// matchers.go
+package matchers
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// Type is the label matching operator.
+type Type int
+
+const (
+	Equal        Type = iota // =
+	NotEqual                 // !=
+	RegexMatch               // =~
+	RegexNoMatch             // !~
+)
+
+var typeOperators = []string{"=", "!=", "=~", "!~"}
+
+func (t Type) String() string {
+	if t < 0 || int(t) >= len(typeOperators) {
+		return fmt.Sprintf("Type(%d)", t)
+	}
+	return typeOperators[t]
+}
+
+// Matcher matches the value of a label. A missing label has the empty value.
+type Matcher struct {
+	Name  model.LabelName
+	Type  Type
+	Value string
+	re    *regexp.Regexp
+}
+
+// New returns a Matcher, regular expressions are anchored as in PromQL.
+func New(name model.LabelName, t Type, value string) (*Matcher, error) {
+	m := &Matcher{Name: name, Type: t, Value: value}
+	if t == RegexMatch || t == RegexNoMatch {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regular expression [%s]: %v", value, err)
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+func (m *Matcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Name, m.Type, m.Value)
+}
+
+// Matches returns true if v matches
+func (m *Matcher) Matches(v string) bool {
+	switch m.Type {
+	case Equal:
+		return v == m.Value
+	case NotEqual:
+		return v != m.Value
+	case RegexMatch:
+		return m.re.MatchString(v)
+	case RegexNoMatch:
+		return !m.re.MatchString(v)
+	default:
+		return false
+	}
+}
+
+// MatchesAll returns true if every Matcher matches the metric
+func MatchesAll(matchers []*Matcher, metric model.Metric) bool {
+	for _, m := range matchers {
+		if !m.Matches(string(metric[m.Name])) {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse parses comma-separated label matchers, in PromQL syntax and optionally enclosed
+// in braces, e.g. {job="api",instance=~"pod-.*"}
+func Parse(s string) ([]*Matcher, error) {
+	in := strings.TrimSpace(s)
+	if strings.HasPrefix(in, "{") {
+		if !strings.HasSuffix(in, "}") {
+			return nil, fmt.Errorf("Invalid matchers [%s]: missing closing brace", s)
+		}
+		in = in[1 : len(in)-1]
+	}
+
+	var result []*Matcher
+	for {
+		in = strings.TrimSpace(in)
+		if in == "" {
+			return result, nil
+		}
+
+		// label name
+		i := 0
+		for i < len(in) && isNameChar(in[i], i == 0) {
+			i++
+		}
+		if i == 0 {
+			return nil, fmt.Errorf("Invalid matchers [%s]: expected label name at [%s]", s, in)
+		}
+		name := model.LabelName(in[:i])
+		in = strings.TrimSpace(in[i:])
+
+		// operator, longest first
+		t := Type(-1)
+		for _, candidate := range []Type{NotEqual, RegexMatch, RegexNoMatch, Equal} {
+			if strings.HasPrefix(in, candidate.String()) {
+				t = candidate
+				in = strings.TrimSpace(in[len(candidate.String()):])
+				break
+			}
+		}
+		if t < 0 {
+			return nil, fmt.Errorf("Invalid matchers [%s]: expected operator after [%s]", s, name)
+		}
+
+		// quoted value
+		value, rest, err := unquote(in)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid matchers [%s]: %v", s, err)
+		}
+		m, err := New(name, t, value)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+
+		in = strings.TrimSpace(rest)
+		if in != "" {
+			if in[0] != ',' {
+				return nil, fmt.Errorf("Invalid matchers [%s]: expected comma at [%s]", s, in)
+			}
+			in = in[1:]
+		}
+	}
+}
+
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+// unquote returns the leading quoted string of in, and the remainder
+func unquote(in string) (string, string, error) {
+	if in == "" || (in[0] != '"' && in[0] != '\'' && in[0] != '`') {
+		return "", "", fmt.Errorf("expected quoted value at [%s]", in)
+	}
+	quote := in[0]
+	for i := 1; i < len(in); i++ {
+		switch in[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			q := in[:i+1]
+			if quote == '\'' {
+				// strconv only unquotes single characters in single quotes
+				q = `"` + strings.Replace(q[1:i], `"`, `\"`, -1) + `"`
+			}
+			value, err := strconv.Unquote(q)
+			if err != nil {
+				return "", "", fmt.Errorf("invalid quoted value [%s]", in[:i+1])
+			}
+			return value, in[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated quoted value [%s]", in)
+}
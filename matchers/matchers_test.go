@@ -0,0 +1,69 @@
+// matchers_test.go
+package matchers
+
+import (
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestParse(t *testing.T) {
+	ms, err := Parse(`{job="api", instance=~"pod-.*",env!='dev', path!~` + "`/health.*`" + `}`)
+	assertEqual(t, nil, err)
+	assertEqual(t, 4, len(ms))
+	assertEqual(t, `job="api"`, ms[0].String())
+	assertEqual(t, `instance=~"pod-.*"`, ms[1].String())
+	assertEqual(t, `env!="dev"`, ms[2].String())
+	assertEqual(t, `path!~"/health.*"`, ms[3].String())
+
+	ms, err = Parse(`job="a\"b"`)
+	assertEqual(t, nil, err)
+	assertEqual(t, `a"b`, ms[0].Value)
+
+	ms, err = Parse("")
+	assertEqual(t, nil, err)
+	assertEqual(t, 0, len(ms))
+
+	_, err = Parse(`{job="api"`)
+	assertEqual(t, `Invalid matchers [{job="api"]: missing closing brace`, fmt.Sprint(err))
+	_, err = Parse(`job:"api"`)
+	assertEqual(t, `Invalid matchers [job:"api"]: expected operator after [job]`, fmt.Sprint(err))
+	_, err = Parse(`job=api`)
+	assertEqual(t, `Invalid matchers [job=api]: expected quoted value at [api]`, fmt.Sprint(err))
+	_, err = Parse(`job="api" env="dev"`)
+	assertEqual(t, `Invalid matchers [job="api" env="dev"]: expected comma at [env="dev"]`, fmt.Sprint(err))
+	_, err = Parse(`job=~"(api"`)
+	assertEqual(t, true, err != nil)
+}
+
+func TestMatchesAll(t *testing.T) {
+	metric := model.Metric{"job": "api", "instance": "pod-1"}
+
+	ms, _ := Parse(`job="api",instance=~"pod-.*"`)
+	assertEqual(t, true, MatchesAll(ms, metric))
+	// regular expressions are anchored
+	ms, _ = Parse(`instance=~"pod"`)
+	assertEqual(t, false, MatchesAll(ms, metric))
+	ms, _ = Parse(`instance!~"pod-[2-9]"`)
+	assertEqual(t, true, MatchesAll(ms, metric))
+	// missing labels are empty
+	ms, _ = Parse(`env=""`)
+	assertEqual(t, true, MatchesAll(ms, metric))
+	ms, _ = Parse(`env!="", job="api"`)
+	assertEqual(t, false, MatchesAll(ms, metric))
+	assertEqual(t, true, MatchesAll(nil, metric))
+}
+
+func assertEqual(t *testing.T, e interface{}, v interface{}) {
+	if reflect.TypeOf(e) != reflect.TypeOf(v) {
+		debug.PrintStack()
+		t.Fatal(fmt.Sprintf("Expected |%v|, Got |%v|", reflect.TypeOf(e), reflect.TypeOf(v)))
+	}
+	if e != v {
+		debug.PrintStack()
+		t.Fatal(fmt.Sprintf("Expected |%v|, Got |%v|", e, v))
+	}
+}
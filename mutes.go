@@ -0,0 +1,255 @@
+// mutes.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/jshaughn/outlier/matchers"
+	"github.com/jshaughn/outlier/nelson"
+	"github.com/jshaughn/outlier/sink"
+)
+
+// mute suppresses the pending and firing events of rules, for the TS it matches, until it
+// expires or is removed. A rule still pending or firing is then sent, see series.deliver.
+type mute struct {
+	ID string `json:"id"`
+	// Expression optionally limits the mute to the TS of the named TSExpression
+	Expression string `json:"expression,omitempty"`
+	// Matchers select the muted TS, in PromQL syntax
+	Matchers string `json:"matchers"`
+	// Rules are the muted rule names, all rules if empty
+	Rules     []string  `json:"rules,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Comment   string    `json:"comment,omitempty"`
+	matchers  []*matchers.Matcher
+}
+
+// init validates the mute and parses its matchers. An Expression or Matchers is required,
+// so a mute never silences every TS.
+func (m *mute) init() error {
+	if m.ExpiresAt.IsZero() {
+		return errors.New("ExpiresAt must be set")
+	}
+	parsed, err := matchers.Parse(m.Matchers)
+	if err != nil {
+		return err
+	}
+	if m.Expression == "" && len(parsed) == 0 {
+		return errors.New("Expression or matchers must be set")
+	}
+	m.matchers = parsed
+	return nil
+}
+
+// matches returns true if the rule of the TS is muted
+func (m *mute) matches(expression string, metric model.Metric, rule string) bool {
+	if m.Expression != "" && m.Expression != expression {
+		return false
+	}
+	if len(m.Rules) > 0 {
+		found := false
+		for _, r := range m.Rules {
+			if r == rule {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return matchers.MatchesAll(m.matchers, metric)
+}
+
+// muteStore holds the active mutes, persisted to path if set
+type muteStore struct {
+	sync.Mutex
+	path  string
+	mutes map[string]*mute
+}
+
+// mutes are the active mutes, loaded at startup
+var mutes = &muteStore{mutes: make(map[string]*mute)}
+
+// load replaces the mutes with those persisted at path, if it exists. Expired mutes are dropped.
+func (s *muteStore) load(path string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.path = path
+	s.mutes = make(map[string]*mute)
+	if path == "" {
+		return nil
+	}
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var loaded []*mute
+	if err = json.Unmarshal(bytes, &loaded); err != nil {
+		return fmt.Errorf("Invalid mute file [%s]: %v", path, err)
+	}
+	now := time.Now()
+	for _, m := range loaded {
+		if err = m.init(); err != nil {
+			return fmt.Errorf("Invalid mute file [%s]: mute [%s]: %v", path, m.ID, err)
+		}
+		if m.ExpiresAt.After(now) {
+			s.mutes[m.ID] = m
+		}
+	}
+	fmt.Printf("Restored [%v] mutes from %s\n", len(s.mutes), path)
+	return nil
+}
+
+// add stores m, assigning its ID
+func (s *muteStore) add(m *mute) error {
+	if err := m.init(); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	m.ID = fmt.Sprintf("%016x", rand.Int63())
+	s.mutes[m.ID] = m
+	return s.save()
+}
+
+// remove deletes the mute, returning false if it is not active
+func (s *muteStore) remove(id string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.mutes[id]; !ok {
+		return false, nil
+	}
+	delete(s.mutes, id)
+	return true, s.save()
+}
+
+// active returns the unexpired mutes, ordered by expiry
+func (s *muteStore) active() []*mute {
+	s.Lock()
+	defer s.Unlock()
+	s.expire()
+
+	result := make([]*mute, 0, len(s.mutes))
+	for _, m := range s.mutes {
+		result = append(result, m)
+	}
+	sort.Slice(result,
+		func(i, j int) bool {
+			return result[i].ExpiresAt.Before(result[j].ExpiresAt)
+		})
+	return result
+}
+
+// muted returns true if the rule of the TS is muted
+func (s *muteStore) muted(expression string, metric model.Metric, rule string) bool {
+	s.Lock()
+	defer s.Unlock()
+	s.expire()
+
+	for _, m := range s.mutes {
+		if m.matches(expression, metric, rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// expire drops the expired mutes, hold the lock. The file is rewritten on the next change.
+func (s *muteStore) expire() {
+	now := time.Now()
+	for id, m := range s.mutes {
+		if !m.ExpiresAt.After(now) {
+			delete(s.mutes, id)
+		}
+	}
+}
+
+// save persists the mutes to path, if set. Hold the lock.
+func (s *muteStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.expire()
+	list := make([]*mute, 0, len(s.mutes))
+	for _, m := range s.mutes {
+		list = append(list, m)
+	}
+	bytes, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(bytes); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// deliver returns the events to send for the transitions of the TS. Pending and firing
+// transitions of muted rules are held, and sent once the rule is no longer muted and has not
+// since stopped violating. The end of a violation is sent only if the sinks were sent its
+// start. Hold the lock.
+func (ser *series) deliver(transitions []nelson.Transition) (events []sink.Event) {
+	k := seriesKey(ser.expression, ser.metric)
+	for _, t := range transitions {
+		held, isHeld := ser.muted[t.Rule]
+		if isHeld {
+			// report the change from the state the sinks were last sent
+			t.From = held.From
+			delete(ser.muted, t.Rule)
+		}
+		if t.To == nelson.Pending || t.To == nelson.Firing {
+			if mutes.muted(ser.expression, ser.metric, t.Rule) {
+				fmt.Printf("Muted %s %s -> %s\n", k, t.Rule, t.To)
+				if ser.muted == nil {
+					ser.muted = make(map[string]nelson.Transition)
+				}
+				ser.muted[t.Rule] = t
+				continue
+			}
+		} else if t.From == nelson.Inactive || t.From == nelson.Resolved {
+			// the violation ended while muted
+			continue
+		}
+		events = append(events, newEvent(ser.expression, ser.metric, t))
+	}
+
+	for _, r := range ser.data.Rules {
+		if t, ok := ser.muted[r.Name]; ok && !mutes.muted(ser.expression, ser.metric, r.Name) {
+			fmt.Printf("Unmuted %s %s -> %s\n", k, t.Rule, t.To)
+			delete(ser.muted, r.Name)
+			events = append(events, newEvent(ser.expression, ser.metric, t))
+		}
+	}
+	return events
+}
@@ -0,0 +1,117 @@
+// mutes_test.go
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/jshaughn/outlier/nelson"
+)
+
+// testMutes replaces the mutes with an empty store persisted to path, returning a function
+// restoring them
+func testMutes(t *testing.T, path string) func() {
+	saved := mutes
+	mutes = &muteStore{mutes: make(map[string]*mute)}
+	assertEqual(t, nil, mutes.load(path))
+	return func() { mutes = saved }
+}
+
+// testMute adds a mute of the TS matching matchers, for an hour
+func testMute(t *testing.T, matchers string) *mute {
+	m := &mute{Matchers: matchers, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	assertEqual(t, nil, mutes.add(m))
+	return m
+}
+
+// a muted transition is sent once the mute is removed or expires, if still violating
+func TestDeliverMuted(t *testing.T) {
+	defer testMutes(t, "")()
+	ts := testExpression(t, "x")
+	ser := newSeries(model.Metric{"a": "1"}, ts, defaultOptions())
+	rule := ser.data.Rules[0].Name
+	m := testMute(t, `a="1"`)
+
+	events := ser.deliver([]nelson.Transition{
+		{Rule: rule, From: nelson.Inactive, To: nelson.Pending},
+		{Rule: rule, From: nelson.Pending, To: nelson.Firing},
+	})
+	assertEqual(t, 0, len(events))
+	assertEqual(t, 0, len(ser.deliver(nil)))
+
+	removed, err := mutes.remove(m.ID)
+	assertEqual(t, true, removed)
+	assertEqual(t, nil, err)
+	events = ser.deliver(nil)
+	assertEqual(t, 1, len(events))
+	assertEqual(t, rule, events[0].Rule)
+	assertEqual(t, nelson.Inactive.String(), events[0].From)
+	assertEqual(t, nelson.Firing.String(), events[0].State)
+	assertEqual(t, 0, len(ser.deliver(nil)))
+
+	events = ser.deliver([]nelson.Transition{{Rule: rule, From: nelson.Firing, To: nelson.Resolved}})
+	assertEqual(t, 1, len(events))
+	assertEqual(t, nelson.Resolved.String(), events[0].State)
+
+	// expiry
+	m = testMute(t, `a="1"`)
+	assertEqual(t, 0, len(ser.deliver([]nelson.Transition{{Rule: rule, From: nelson.Resolved, To: nelson.Firing}})))
+	m.ExpiresAt = time.Now().Add(-time.Second)
+	events = ser.deliver(nil)
+	assertEqual(t, 1, len(events))
+	assertEqual(t, nelson.Resolved.String(), events[0].From)
+	assertEqual(t, nelson.Firing.String(), events[0].State)
+}
+
+// a violation starting and ending while muted is not sent, one started before is ended
+func TestDeliverEndedWhileMuted(t *testing.T) {
+	defer testMutes(t, "")()
+	ts := testExpression(t, "x")
+	ser := newSeries(model.Metric{"a": "1"}, ts, defaultOptions())
+	rule := ser.data.Rules[0].Name
+	m := testMute(t, `a="1"`)
+
+	events := ser.deliver([]nelson.Transition{
+		{Rule: rule, From: nelson.Inactive, To: nelson.Firing},
+		{Rule: rule, From: nelson.Firing, To: nelson.Resolved},
+	})
+	assertEqual(t, 0, len(events))
+	mutes.remove(m.ID)
+	assertEqual(t, 0, len(ser.deliver(nil)))
+
+	events = ser.deliver([]nelson.Transition{{Rule: rule, From: nelson.Inactive, To: nelson.Pending}})
+	assertEqual(t, 1, len(events))
+	testMute(t, `a="1"`)
+	events = ser.deliver([]nelson.Transition{
+		{Rule: rule, From: nelson.Pending, To: nelson.Firing},
+		{Rule: rule, From: nelson.Firing, To: nelson.Resolved},
+	})
+	assertEqual(t, 1, len(events))
+	assertEqual(t, nelson.Pending.String(), events[0].From)
+	assertEqual(t, nelson.Resolved.String(), events[0].State)
+}
+
+// only the selected rules of the matching TS are muted
+func TestMuteMatches(t *testing.T) {
+	m := &mute{Expression: "x", Matchers: `a=~"1|2"`, Rules: []string{"r1"}, ExpiresAt: time.Now().Add(time.Hour)}
+	assertEqual(t, nil, m.init())
+	assertEqual(t, true, m.matches("x", model.Metric{"a": "1"}, "r1"))
+	assertEqual(t, false, m.matches("y", model.Metric{"a": "1"}, "r1"))
+	assertEqual(t, false, m.matches("x", model.Metric{"a": "3"}, "r1"))
+	assertEqual(t, false, m.matches("x", model.Metric{"a": "1"}, "r2"))
+}
+
+// a mute requires an expression or matchers
+func TestMuteInit(t *testing.T) {
+	m := &mute{ExpiresAt: time.Now().Add(time.Hour)}
+	assertEqual(t, "Expression or matchers must be set", m.init().Error())
+	m.Matchers = "{}"
+	assertEqual(t, "Expression or matchers must be set", m.init().Error())
+	m.Expression = "x"
+	assertEqual(t, nil, m.init())
+
+	m = &mute{Matchers: `a="1"`}
+	assertEqual(t, "ExpiresAt must be set", m.init().Error())
+}
@@ -0,0 +1,144 @@
+// alert.go
+package nelson
+
+import (
+	"fmt"
+	"time"
+)
+
+// AlertState is the lifecycle state of a Rule for a particular time series.
+type AlertState int
+
+const (
+	// Inactive Rules have not been violated, or stopped violating before firing
+	Inactive AlertState = iota
+	// Pending Rules are violating, but not yet for AlertOptions.For
+	Pending
+	// Firing Rules have been violating for at least AlertOptions.For
+	Firing
+	// Resolved Rules were Firing, but have since had AlertOptions.ResolveAfter
+	// consecutive non-violating Samples. Otherwise they behave as Inactive.
+	Resolved
+)
+
+var alertStateNames = []string{"inactive", "pending", "firing", "resolved"}
+
+func (s AlertState) String() string {
+	if s < 0 || int(s) >= len(alertStateNames) {
+		return fmt.Sprintf("AlertState(%d)", s)
+	}
+	return alertStateNames[s]
+}
+
+// AlertOptions configures the AlertState transitions. The zero value fires on the
+// first violation and resolves on the first non-violating Sample.
+type AlertOptions struct {
+	// For is how long, in Sample time, a Rule must be violating before it is Firing
+	For time.Duration
+	// ResolveAfter is the number of consecutive non-violating Samples that end a
+	// violation, defaults to 1. A Pending Rule becomes Inactive, a Firing Rule Resolved.
+	ResolveAfter int
+}
+
+func (a AlertOptions) withDefaults() AlertOptions {
+	if a.ResolveAfter <= 0 {
+		a.ResolveAfter = 1
+	}
+	return a
+}
+
+// Transition reports a change in the AlertState of a Rule.
+type Transition struct {
+	Rule string
+	From AlertState
+	To   AlertState
+	Time int64 // unix time in ms of the Sample causing the Transition
+	// Violation is the most recent Violation of the Rule
+	Violation Violation
+}
+
+// alertState is the AlertState of a single Rule for a particular time series
+type alertState struct {
+	state AlertState
+	// unix time in ms of the first Violation while Pending or Firing
+	activeAt int64
+	// consecutive non-violating Samples while Pending or Firing
+	clean int
+	// most recent Violation
+	violation *Violation
+}
+
+// update applies the result of evaluating s and returns the Transition, if any
+func (as *alertState) update(r Rule, o AlertOptions, s Sample, v *Violation) *Transition {
+	from := as.state
+	if nil != v {
+		as.violation = v
+		as.clean = 0
+		switch as.state {
+		case Inactive, Resolved:
+			as.activeAt = s.Time()
+			as.state = Pending
+			if o.For <= 0 {
+				as.state = Firing
+			}
+		case Pending:
+			if s.Time()-as.activeAt >= int64(o.For/time.Millisecond) {
+				as.state = Firing
+			}
+		}
+	} else if as.state == Pending || as.state == Firing {
+		as.clean++
+		if as.clean >= o.ResolveAfter {
+			as.clean = 0
+			if as.state == Pending {
+				as.state = Inactive
+			} else {
+				as.state = Resolved
+			}
+		}
+	}
+
+	if as.state == from {
+		return nil
+	}
+	return &Transition{Rule: r.Name, From: from, To: as.state, Time: s.Time(), Violation: *as.violation}
+}
+
+// State returns the AlertState of the named Rule, Inactive if unknown.
+func (d *Data) State(rule string) AlertState {
+	if as, ok := d.alertStates[rule]; ok {
+		return as.state
+	}
+	return Inactive
+}
+
+// Firing returns the names of the Firing Rules, in Rule order.
+func (d *Data) Firing() (firing []string) {
+	for _, r := range d.Rules {
+		if d.alertStates[r.Name].state == Firing {
+			firing = append(firing, r.Name)
+		}
+	}
+	return firing
+}
+
+// ResolveAll ends the violation of every Pending or Firing Rule, e.g. when the TS is no
+// longer tracked, returning the Transitions in Rule order. Firing Rules are Resolved and
+// Pending Rules become Inactive, at t (unix time in ms).
+func (d *Data) ResolveAll(t int64) (transitions []Transition) {
+	for _, r := range d.Rules {
+		as := d.alertStates[r.Name]
+		from := as.state
+		switch from {
+		case Pending:
+			as.state = Inactive
+		case Firing:
+			as.state = Resolved
+		default:
+			continue
+		}
+		as.clean = 0
+		transitions = append(transitions, Transition{Rule: r.Name, From: from, To: as.state, Time: t, Violation: *as.violation})
+	}
+	return transitions
+}
@@ -0,0 +1,104 @@
+// alert_test.go
+package nelson
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Rule1 fires immediately and resolves after 2 clean samples
+// [ 18, 18 ], 10, [ 18 ], 10, 10, [ 18 ]
+func TestAlertFiringResolved(t *testing.T) {
+	d := NewDataWithOptions("test-metric", Options{SampleSize: 10, Rules: []Rule{Rule1}, Alert: AlertOptions{ResolveAfter: 2}})
+	d.AddSamples(statSamples)
+	assertEqual(t, Inactive, d.State(Rule1.Name))
+
+	result := d.AddSample(testSample{200000, 18.0})
+	assertEqual(t, 1, len(result.Transitions))
+	tr := result.Transitions[0]
+	assertEqual(t, Rule1.Name, tr.Rule)
+	assertEqual(t, Inactive, tr.From)
+	assertEqual(t, Firing, tr.To)
+	assertEqual(t, int64(200000), tr.Time)
+	assertEqual(t, int64(200000), tr.Violation.Time)
+	assertEqual(t, 1, len(d.Firing()))
+
+	assertEqual(t, 0, len(d.AddSample(testSample{201000, 18.0}).Transitions))
+	assertEqual(t, 0, len(d.AddSample(testSample{202000, 10.0}).Transitions))
+	assertEqual(t, 0, len(d.AddSample(testSample{203000, 18.0}).Transitions))
+	assertEqual(t, 0, len(d.AddSample(testSample{204000, 10.0}).Transitions))
+	assertEqual(t, Firing, d.State(Rule1.Name))
+
+	result = d.AddSample(testSample{205000, 10.0})
+	assertEqual(t, 1, len(result.Transitions))
+	tr = result.Transitions[0]
+	assertEqual(t, Firing, tr.From)
+	assertEqual(t, Resolved, tr.To)
+	assertEqual(t, int64(205000), tr.Time)
+	// the most recent violation
+	assertEqual(t, int64(203000), tr.Violation.Time)
+	assertEqual(t, 0, len(d.Firing()))
+	// the violation count is unaffected
+	assertEqual(t, 3, d.Violations[Rule1.Name])
+
+	result = d.AddSample(testSample{206000, 18.0})
+	assertEqual(t, Resolved, result.Transitions[0].From)
+	assertEqual(t, Firing, result.Transitions[0].To)
+
+	d.Clear()
+	assertEqual(t, Inactive, d.State(Rule1.Name))
+}
+
+// Rule1 must violate for 2s before firing
+// [ 18, 18 ], 10, [ 18, 18, 18 ], 10
+func TestAlertPending(t *testing.T) {
+	d := NewDataWithOptions("test-metric", Options{SampleSize: 10, Rules: []Rule{Rule1}, Alert: AlertOptions{For: 2 * time.Second}})
+	d.AddSamples(statSamples)
+
+	result := d.AddSample(testSample{200000, 18.0})
+	assertEqual(t, Pending, result.Transitions[0].To)
+	assertEqual(t, 0, len(d.AddSample(testSample{201000, 18.0}).Transitions))
+	result = d.AddSample(testSample{202000, 10.0})
+	assertEqual(t, Pending, result.Transitions[0].From)
+	assertEqual(t, Inactive, result.Transitions[0].To)
+
+	assertEqual(t, Pending, d.AddSample(testSample{203000, 18.0}).Transitions[0].To)
+	assertEqual(t, 0, len(d.AddSample(testSample{204000, 18.0}).Transitions))
+	result = d.AddSample(testSample{205000, 18.0})
+	assertEqual(t, Pending, result.Transitions[0].From)
+	assertEqual(t, Firing, result.Transitions[0].To)
+
+	// firing survives a snapshot and restore
+	snapshot, err := d.Snapshot()
+	assertEqual(t, nil, err)
+	bytes, err := json.Marshal(snapshot)
+	assertEqual(t, nil, err)
+	snapshot = Snapshot{}
+	assertEqual(t, nil, json.Unmarshal(bytes, &snapshot))
+	restored := NewDataWithOptions("test-metric", Options{SampleSize: 10, Rules: []Rule{Rule1}, Alert: AlertOptions{For: 2 * time.Second}})
+	assertEqual(t, nil, restored.Restore(snapshot))
+	assertEqual(t, Firing, restored.State(Rule1.Name))
+
+	result = restored.AddSample(testSample{206000, 10.0})
+	assertEqual(t, Resolved, result.Transitions[0].To)
+	assertEqual(t, int64(205000), result.Transitions[0].Violation.Time)
+	assertEqual(t, "test-metric", result.Transitions[0].Violation.Metric)
+}
+
+func TestAlertResolveAll(t *testing.T) {
+	d := NewDataWithOptions("test-metric", Options{SampleSize: 10, Rules: []Rule{Rule1, Rule2}, Alert: AlertOptions{ResolveAfter: 5}})
+	d.AddSamples(statSamples)
+	assertEqual(t, 0, len(d.ResolveAll(200000)))
+
+	d.AddSample(testSample{200000, 18.0})
+	assertEqual(t, Firing, d.State(Rule1.Name))
+
+	transitions := d.ResolveAll(300000)
+	assertEqual(t, 1, len(transitions))
+	assertEqual(t, Rule1.Name, transitions[0].Rule)
+	assertEqual(t, Resolved, transitions[0].To)
+	assertEqual(t, int64(300000), transitions[0].Time)
+	assertEqual(t, int64(200000), transitions[0].Violation.Time)
+	assertEqual(t, Resolved, d.State(Rule1.Name))
+}
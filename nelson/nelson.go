@@ -4,128 +4,45 @@ package nelson
 import (
 	"container/list"
 	"fmt"
-	"math"
-
-	"github.com/gonum/stat"
+	"sort"
+	"time"
 )
 
-type Rule struct {
-	Name        string
-	Description string
-	f           func(d *Data, v float64) bool
-}
-
-var Rule1 = Rule{
-	"Rule1",
-	"One point is more than 3 standard deviations from the mean.",
-	(*Data).rule1,
-}
-var Rule2 = Rule{
-	"Rule2",
-	"Nine (or more) points in a row are on the same side of the mean.",
-	(*Data).rule2,
-}
-var Rule3 = Rule{
-	"Rule3",
-	"Six (or more) points in a row are continually increasing (or decreasing).",
-	(*Data).rule3,
-}
-var Rule4 = Rule{
-	"Rule4",
-	"Fourteen (or more) points in a row alternate in direction, increasing then decreasing.",
-	(*Data).rule4,
-}
-var Rule5 = Rule{
-	"Rule5",
-	"At least 2 of 3 points in a row are > 2 standard deviations from the mean in the same direction.",
-	(*Data).rule5,
-}
-var Rule6 = Rule{
-	"Rule6",
-	"At least 4 of 5 points in a row are > 1 standard deviation from the mean in the same direction.",
-	(*Data).rule6,
-}
-var Rule7 = Rule{
-	"Rule7",
-	"Fifteen points in a row are all within 1 standard deviation of the mean on either side of the mean.",
-	(*Data).rule7,
-}
-var Rule8 = Rule{
-	"Rule8",
-	"Eight points in a row exist, but none within 1 standard deviation of the mean and the points are in both directions from the mean.",
-	(*Data).rule8,
-}
-
-func (r Rule) String() string {
-	return r.Name
-}
-
-// CommonRules includes all rules other than: Rule7
-var CommonRules = []Rule{Rule1, Rule2, Rule3, Rule4, Rule5, Rule6, Rule8}
-
-// AllRules is not recommended for metrics with little to no variance when well-behaved
-var AllRules = []Rule{Rule1, Rule2, Rule3, Rule4, Rule5, Rule6, Rule7, Rule8}
-
-// MaxSamples indicates the max number of Samples needed to evaluate any Rule.
-// Rule7 requires the most Samples, 15.
-const MaxSamples = 15
-
 type Sample interface {
 	Time() int64 // unix time in ms
 	Val() float64
 }
 
-type statistics struct {
-	ready bool
-	// number of samples required to determine mean and stddev
-	sampleSize        int
-	numSamples        int
-	values            []float64
-	mean              float64
-	standardDeviation float64
-	twoDeviations     float64
-	threeDeviations   float64
+// SampleResult reports the outcome of adding a single Sample to Data.
+type SampleResult struct {
+	Time  int64 // unix time in ms
+	Value float64
+	// Evaluated is false if the Sample was consumed establishing the stats
+	Evaluated bool
+	// Violated Rules, in Rule order
+	Violations []Violation
+	// AlertState changes caused by the Sample, in Rule order
+	Transitions []Transition
 }
 
-func (s statistics) String() string {
-	if !s.ready {
-		return fmt.Sprintf("Waiting on [%v] samples", s.sampleSize-s.numSamples)
-	}
-	return fmt.Sprintf("mean=%.2f, stddev=%.2f, twodev=%.2f, threedev=%.2f",
-		s.mean, s.standardDeviation, s.twoDeviations, s.threeDeviations)
-}
-
-func newStatistics(sampleSize int) statistics {
-	return statistics{
-		sampleSize: sampleSize,
-		values:     make([]float64, sampleSize),
-	}
+// Violation describes a Rule violated by a Sample.
+type Violation struct {
+	Rule        string `json:"rule"`
+	Description string `json:"description"`
+	// Metric identifies the TS, it is the Data Metric
+	Metric interface{} `json:"metric"`
+	Time   int64       `json:"time"` // unix time in ms
+	Value  float64     `json:"value"`
+	// the baseline stats the Sample was evaluated against
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	// Window holds the Samples that formed the pattern, oldest first, ending with the
+	// violating Sample
+	Window []Point `json:"window"`
 }
 
-func (s *statistics) clear() {
-	s.numSamples = 0
-	s.values = make([]float64, s.sampleSize)
-	s.mean = 0
-	s.standardDeviation = 0
-	s.twoDeviations = 0
-	s.threeDeviations = 0
-}
-
-// addSample returns true if stats are ready, false otherwise. Values
-// added after stats are ready are ignored.
-func (s *statistics) addSample(sample Sample) bool {
-	if !s.ready {
-		s.values[s.numSamples] = sample.Val()
-		s.numSamples++
-		if s.numSamples == s.sampleSize {
-			s.mean = stat.Mean(s.values, nil)
-			s.standardDeviation = stat.StdDev(s.values, nil)
-			s.twoDeviations = 2 * s.standardDeviation
-			s.threeDeviations = 3 * s.standardDeviation
-			s.ready = true
-		}
-	}
-	return s.ready
+func (v Violation) String() string {
+	return fmt.Sprintf("%s %v @%v value=%.2f mean=%.2f stddev=%.2f window=%v", v.Rule, v.Metric, v.Time, v.Value, v.Mean, v.StdDev, v.Window)
 }
 
 // Data tracks nelson rule evaluations for a particular time series.  Each Data
@@ -134,44 +51,128 @@ func (s *statistics) addSample(sample Sample) bool {
 type Data struct {
 	Metric     interface{}
 	Violations map[string]int
-	// List of Sample Elements backing the current Rule evaluations
+	// List of Sample Elements backing the current Rule evaluations. With Seasonality it
+	// holds only Samples of the current bucket.
 	ViolationsData *list.List
-	Rules          []Rule
-	stats          statistics
-	// List of Rule Elements indicating currently violated Rules
-	rule2Count             int
-	rule3Count             int
-	rule3PreviousSample    *float64
-	rule4Count             int
-	rule4PreviousSample    *float64
-	rule4PreviousDirection string
-	// List of Sample.Value() Elements
-	rule5LastThree *list.List
-	// List of Sample.Value() Elements
-	rule6LastFive *list.List
-	rule7Count    int
-	rule8Count    int
+	// Rules to evaluate, Rule names must be unique
+	Rules   []Rule
+	options Options
+	// the baseline stats for the current Sample
+	stats *statistics
+	// key=seasonal bucket, value=baseline stats for the bucket
+	seasonalStats map[int]*statistics
+	// key=Rule.Name, value=state of the Rule for this TS, for the current seasonal bucket
+	ruleStates map[string]*RuleState
+	// the seasonal bucket of the most recent Sample, -1 if none
+	bucket int
+	// key=Rule.Name, value=AlertState of the Rule for this TS
+	alertStates map[string]*alertState
+	// max number of Samples needed to evaluate the Rules
+	maxSamples int
+	// unix time in ms of the newest Sample added, 0 if none
+	lastTime int64
+}
+
+// Options configures a Data.
+type Options struct {
+	// SampleSize is the number of Samples used to establish the baseline stats
+	SampleSize int
+	// Rules to evaluate, defaults to AllRules
+	Rules []Rule
+	// Baseline determines how the baseline stats evolve, defaults to Frozen
+	Baseline Baseline
+	// Estimator determines how the baseline mean and stddev are calculated, defaults
+	// to Standard. Robust estimators limit the influence of outliers in the baseline.
+	Estimator Estimator
+	// Trim is the fraction of values trimmed from each end by the Trimmed and Winsorized
+	// estimators, defaults to DefaultTrim
+	Trim float64
+	// Seasonality determines whether Samples are evaluated against per-bucket baselines,
+	// defaults to NoSeasonality
+	Seasonality Seasonality
+	// Location is the time zone used to determine seasonal buckets, defaults to UTC
+	Location *time.Location
+	// Alert configures the AlertState transitions
+	Alert AlertOptions
 }
 
+// NewData returns Data evaluating the rules against a Frozen baseline established from
+// sampleSize Samples.
 func NewData(m interface{}, sampleSize int, rules ...Rule) Data {
+	return NewDataWithOptions(m, Options{SampleSize: sampleSize, Rules: rules})
+}
+
+// NewDataWithOptions returns Data configured by o.
+func NewDataWithOptions(m interface{}, o Options) Data {
+	rules := o.Rules
 	if nil == rules {
 		rules = AllRules
 	}
+	o.Rules = rules
+	if nil == o.Location {
+		o.Location = time.UTC
+	}
+	o.Alert = o.Alert.withDefaults()
+
+	ruleStates := make(map[string]*RuleState, len(rules))
+	alertStates := make(map[string]*alertState, len(rules))
+	for _, r := range rules {
+		ruleStates[r.Name] = newRuleState()
+		alertStates[r.Name] = &alertState{}
+	}
 
 	return Data{
 		Metric:         m,
 		Rules:          rules,
 		Violations:     make(map[string]int),
 		ViolationsData: list.New(),
-		rule5LastThree: list.New(),
-		rule6LastFive:  list.New(),
-		stats:          newStatistics(sampleSize),
+		options:        o,
+		stats:          o.newStatistics(),
+		seasonalStats:  make(map[int]*statistics),
+		ruleStates:     ruleStates,
+		bucket:         -1,
+		alertStates:    alertStates,
+		maxSamples:     MaxSamples(rules...),
+	}
+}
+
+func (o Options) newStatistics() *statistics {
+	s := newStatistics(o.SampleSize, o.Baseline, o.Estimator, o.Trim)
+	return &s
+}
+
+// statsFor returns the baseline stats to use for s, per the Seasonality. When s is in a
+// different bucket than the previous Sample the Rule state is reset, so a run of Samples
+// is only ever counted against a single baseline.
+func (d *Data) statsFor(s Sample) *statistics {
+	if d.options.Seasonality == NoSeasonality {
+		return d.stats
 	}
+
+	bucket := d.options.Seasonality.bucket(s.Time(), d.options.Location)
+	if bucket != d.bucket {
+		d.bucket = bucket
+		d.clearRuleStates()
+	}
+	stats, ok := d.seasonalStats[bucket]
+	if !ok {
+		stats = d.options.newStatistics()
+		d.seasonalStats[bucket] = stats
+	}
+	return stats
 }
 
 func (d Data) String() string {
+	stats := fmt.Sprintf("%+v", d.stats)
+	if d.options.Seasonality != NoSeasonality {
+		stats = fmt.Sprintf("seasonality=%v(%v buckets), current: %v", d.options.Seasonality, len(d.seasonalStats), stats)
+	}
+
 	if len(d.Violations) == 0 {
-		return fmt.Sprintf("%v:\n\tNo Violations, stats:%+v", d.Metric, d.stats)
+		return fmt.Sprintf("%v:\n\tNo Violations, stats:%v", d.Metric, stats)
+	}
+	if firing := d.Firing(); len(firing) > 0 {
+		stats = fmt.Sprintf("%v\n\tfiring: %v", stats, firing)
 	}
 
 	var vr, comma string
@@ -187,243 +188,180 @@ func (d Data) String() string {
 	}
 	vd += "]"
 
-	return fmt.Sprintf("%v:\n\tviolations: %v\n\tstats: %v\n\tvalues: %v", d.Metric, vr, d.stats, vd)
+	return fmt.Sprintf("%v:\n\tviolations: %v\n\tstats: %v\n\tvalues: %v", d.Metric, vr, stats, vd)
 }
 
+// Clear resets the baseline stats, Violations and Rule state. AlertStates are reset to
+// Inactive, without Transitions.
 func (d *Data) Clear() {
 	d.stats.clear()
+	d.seasonalStats = make(map[int]*statistics)
 	d.Violations = make(map[string]int)
-	d.ViolationsData = d.ViolationsData.Init()
-	d.rule2Count = 0
-	d.rule3Count = 0
-	d.rule3PreviousSample = nil
-	d.rule4Count = 0
-	d.rule4PreviousSample = nil
-	d.rule4PreviousDirection = ""
-	d.rule5LastThree.Init()
-	d.rule6LastFive.Init()
-	d.rule7Count = 0
-	d.rule8Count = 0
-}
-
-func (d *Data) hasViolations() bool {
-	return len(d.Violations) > 0
-}
-
-func (d *Data) AddSample(s Sample) map[string]bool {
-	if d.stats.ready {
-		return d.evaluate(s)
+	d.clearRuleStates()
+	d.bucket = -1
+	for k := range d.alertStates {
+		d.alertStates[k] = &alertState{}
 	}
-	d.stats.addSample(s)
-	return nil
 }
 
-func (d *Data) evaluate(s Sample) (result map[string]bool) {
-	d.ViolationsData.PushFront(s)
-	if d.ViolationsData.Len() > MaxSamples {
-		d.ViolationsData.Remove(d.ViolationsData.Back())
-	}
-
-	result = make(map[string]bool)
-	for _, r := range d.Rules {
-		violation := r.f(d, s.Val())
-		result[r.Name] = violation
-		if violation {
-			fmt.Printf("Violation! %s %v\n", r.Name, d.Metric)
-			d.Violations[r.Name] += 1
-		}
+// clearRuleStates resets the Rule state and the Samples backing the Rule evaluations
+func (d *Data) clearRuleStates() {
+	d.ViolationsData = d.ViolationsData.Init()
+	for _, rs := range d.ruleStates {
+		rs.clear()
 	}
+}
 
-	return result
+// Ready returns true if the baseline stats are established and Samples are being evaluated.
+// With Seasonality it reports the current bucket, that of the most recent Sample, see
+// Buckets for every bucket.
+func (d *Data) Ready() bool {
+	return d.stats.ready
 }
 
-// one point is more than 3 standard deviations from the mean
-func (d *Data) rule1(s float64) bool {
-	if d.stats.standardDeviation == 0.0 {
-		return false
-	}
+// SamplesUntilReady returns the number of Samples still needed to establish the baseline
+// stats, 0 when Ready. With Seasonality it reports the current bucket.
+func (d *Data) SamplesUntilReady() int {
+	return d.stats.samplesUntilReady()
+}
 
-	return math.Abs(s-d.stats.mean) > d.stats.threeDeviations
+// Mean returns the baseline mean, valid only when Ready. With Seasonality it reports the
+// current bucket.
+func (d *Data) Mean() float64 {
+	return d.stats.mean
 }
 
-// Nine (or more) points in a row are on the same side of the mean
-func (d *Data) rule2(s float64) bool {
-	switch {
-	case s > d.stats.mean:
-		if d.rule2Count > 0 {
-			d.rule2Count++
-		} else {
-			d.rule2Count = 1
-		}
-	case s < d.stats.mean:
-		if d.rule2Count < 0 {
-			d.rule2Count--
-		} else {
-			d.rule2Count = -1
-		}
-	default:
-		d.rule2Count = 0
-	}
+// StdDev returns the baseline standard deviation, valid only when Ready. With Seasonality
+// it reports the current bucket.
+func (d *Data) StdDev() float64 {
+	return d.stats.standardDeviation
+}
 
-	return math.Abs(float64(d.rule2Count)) >= 9
+// BucketStats is the baseline state of a seasonal bucket.
+type BucketStats struct {
+	Bucket            int  `json:"bucket"`
+	Ready             bool `json:"ready"`
+	SamplesUntilReady int  `json:"samplesUntilReady"`
+	// Mean and StdDev are valid only when Ready
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
 }
 
-// Six (or more) points in a row are continually increasing (or decreasing)
-func (d *Data) rule3(s float64) bool {
-	if nil == d.rule3PreviousSample {
-		d.rule3PreviousSample = &s
-		d.rule3Count = 0
-		return false
+// Buckets returns the baseline state of each seasonal bucket with Samples, ordered by
+// bucket. Without Seasonality the single baseline is returned as bucket 0.
+func (d *Data) Buckets() []BucketStats {
+	if d.options.Seasonality == NoSeasonality {
+		return []BucketStats{d.stats.bucketStats(0)}
 	}
 
-	if s > *d.rule3PreviousSample {
-		if d.rule3Count > 0 {
-			d.rule3Count++
-		} else {
-			d.rule3Count = 1
-		}
-	} else if s < *d.rule3PreviousSample {
-		if d.rule3Count < 0 {
-			d.rule3Count--
-		} else {
-			d.rule3Count = -1
-		}
-	} else {
-		d.rule3Count = 0
+	result := make([]BucketStats, 0, len(d.seasonalStats))
+	for bucket, stats := range d.seasonalStats {
+		result = append(result, stats.bucketStats(bucket))
 	}
-
-	*d.rule3PreviousSample = s
-
-	return math.Abs(float64(d.rule3Count)) >= 6
+	sort.Slice(result,
+		func(i, j int) bool {
+			return result[i].Bucket < result[j].Bucket
+		})
+	return result
 }
 
-// Fourteen (or more) points in a row alternate in direction, increasing then decreasing
-func (d *Data) rule4(s float64) bool {
-	if nil == d.rule4PreviousSample || s == *d.rule4PreviousSample {
-		d.rule4PreviousSample = &s
-		d.rule4PreviousDirection = "="
-		d.rule4Count = 0
-		return false
+// Recent returns up to n of the most recently evaluated Samples, newest first. The
+// Sample currently being evaluated is included.
+func (d *Data) Recent(n int) []Sample {
+	if n > d.ViolationsData.Len() {
+		n = d.ViolationsData.Len()
 	}
-
-	sampleDirection := ">"
-	if s <= *d.rule4PreviousSample {
-		sampleDirection = "<"
+	recent := make([]Sample, 0, n)
+	for e := d.ViolationsData.Front(); e != nil && len(recent) < n; e = e.Next() {
+		recent = append(recent, e.Value.(Sample))
 	}
+	return recent
+}
 
-	if sampleDirection == d.rule4PreviousDirection {
-		d.rule4Count = 0
-	} else {
-		d.rule4Count++
-	}
-
-	*d.rule4PreviousSample = s
-	d.rule4PreviousDirection = sampleDirection
-
-	return math.Abs(float64(d.rule4Count)) >= 14
-
+// LastTime returns the unix time in ms of the newest Sample added, or 0 if none. It is
+// not reset by Clear, allowing callers to continue skipping already consumed Samples.
+func (d *Data) LastTime() int64 {
+	return d.lastTime
 }
 
-// At least 2 of 3 points in a row are > 2 standard deviations from the mean in the same direction
-func (d *Data) rule5(s float64) bool {
-	if d.stats.standardDeviation == 0.0 {
-		return false
-	}
+func (d *Data) hasViolations() bool {
+	return len(d.Violations) > 0
+}
 
-	if math.Abs(s-d.stats.mean) > d.stats.twoDeviations {
-		if s > d.stats.mean {
-			d.rule5LastThree.PushFront(">")
-		} else {
-			d.rule5LastThree.PushFront("<")
-		}
-	} else {
-		d.rule5LastThree.PushFront("")
+// AddSample evaluates s against the Rules, returning the Violations and AlertState
+// Transitions. If the baseline stats (for the seasonal bucket of s) are not yet
+// established s is used for the stats and is not Evaluated. Evaluated Samples are then
+// applied to the baseline, per the Baseline mode.
+func (d *Data) AddSample(s Sample) SampleResult {
+	if s.Time() > d.lastTime {
+		d.lastTime = s.Time()
 	}
-
-	if d.rule5LastThree.Len() > 3 {
-		d.rule5LastThree.Remove(d.rule5LastThree.Back())
+	result := SampleResult{Time: s.Time(), Value: s.Val()}
+	d.stats = d.statsFor(s)
+	if d.stats.ready {
+		result.Evaluated = true
+		result.Violations, result.Transitions = d.evaluate(s)
 	}
+	d.stats.addSample(s)
+	return result
+}
 
-	var above, below int
-	for e := d.rule5LastThree.Front(); e != nil; e = e.Next() {
-		switch e.Value.(string) {
-		case ">":
-			above++
-		case "<":
-			below++
-		}
+// AddSamples adds the Samples in time order (oldest first) and returns one
+// SampleResult for each Sample, in the same order.
+func (d *Data) AddSamples(samples []Sample) []SampleResult {
+	sorted := make([]Sample, len(samples))
+	copy(sorted, samples)
+	sort.SliceStable(sorted,
+		func(i, j int) bool {
+			return sorted[i].Time() < sorted[j].Time()
+		})
+
+	results := make([]SampleResult, len(sorted))
+	for i, s := range sorted {
+		results[i] = d.AddSample(s)
 	}
 
-	return above >= 2 || below >= 2
+	return results
 }
 
-// At least 4 of 5 points in a row are > 1 standard deviation from the mean in the same direction
-func (d *Data) rule6(s float64) bool {
-	if d.stats.standardDeviation == 0.0 {
-		return false
+func (d *Data) evaluate(s Sample) (violations []Violation, transitions []Transition) {
+	d.ViolationsData.PushFront(s)
+	if d.ViolationsData.Len() > d.maxSamples {
+		d.ViolationsData.Remove(d.ViolationsData.Back())
 	}
 
-	if math.Abs(s-d.stats.mean) > d.stats.standardDeviation {
-		if s > d.stats.mean {
-			d.rule6LastFive.PushFront(">")
-		} else {
-			d.rule6LastFive.PushFront("<")
+	for _, r := range d.Rules {
+		var violation *Violation
+		if r.f(d, r.Params, d.ruleStates[r.Name], s.Val()) {
+			d.Violations[r.Name] += 1
+			v := d.newViolation(r, s)
+			violations = append(violations, v)
+			violation = &v
 		}
-	} else {
-		d.rule6LastFive.PushFront("")
-	}
-
-	if d.rule6LastFive.Len() > 5 {
-		d.rule6LastFive.Remove(d.rule6LastFive.Back())
-	}
-
-	var above, below int
-	for e := d.rule6LastFive.Front(); e != nil; e = e.Next() {
-		switch e.Value.(string) {
-		case ">":
-			above++
-		case "<":
-			below++
+		if t := d.alertStates[r.Name].update(r, d.options.Alert, s, violation); nil != t {
+			transitions = append(transitions, *t)
 		}
 	}
 
-	return above >= 4 || below >= 4
+	return violations, transitions
 }
 
-// Fifteen points in a row are all within 1 standard deviation of the mean on either side of the mean
-// Note: I have my doubts about this one wrt monitored metrics, i think it may not be uncommon to have
-// a very steady metric. Minimally, I have taken away the flat-line case where all samples are the mean.
-func (d *Data) rule7(s float64) bool {
-	if d.stats.standardDeviation == 0.0 {
-		return false
+func (d *Data) newViolation(r Rule, s Sample) Violation {
+	// Recent is newest first
+	recent := d.Recent(r.samples)
+	window := make([]Point, len(recent))
+	for i, rs := range recent {
+		window[len(recent)-1-i] = Point{T: rs.Time(), V: rs.Val()}
 	}
 
-	if s == d.stats.mean {
-		d.rule7Count = 0
-		return false
+	return Violation{
+		Rule:        r.Name,
+		Description: r.Description,
+		Metric:      d.Metric,
+		Time:        s.Time(),
+		Value:       s.Val(),
+		Mean:        d.stats.mean,
+		StdDev:      d.stats.standardDeviation,
+		Window:      window,
 	}
-
-	if math.Abs(s-d.stats.mean) <= d.stats.standardDeviation {
-		d.rule7Count++
-	} else {
-		d.rule7Count = 0
-	}
-
-	return d.rule7Count >= 15
-}
-
-// Eight points in a row exist, but none within 1 standard deviation of the mean
-// and the points are in both directions from the mean
-func (d *Data) rule8(s float64) bool {
-	if d.stats.standardDeviation == 0.0 {
-		return false
-	}
-
-	if math.Abs(s-d.stats.mean) > d.stats.standardDeviation {
-		d.rule8Count++
-	} else {
-		d.rule8Count = 0
-	}
-
-	return d.rule8Count >= 8
 }
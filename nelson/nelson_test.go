@@ -45,6 +45,17 @@ func TestStats(t *testing.T) {
 	assertEqual(t, "2.58199", fmt.Sprintf("%.5f", d.stats.standardDeviation))
 }
 
+// the samples still needed count down to an established baseline
+func TestSamplesUntilReady(t *testing.T) {
+	d := NewData("test-metric", 10)
+	assertEqual(t, 10, d.SamplesUntilReady())
+	d.AddSamples(statSamples[:4])
+	assertEqual(t, 6, d.SamplesUntilReady())
+	d.AddSamples(statSamples[4:])
+	assertEqual(t, true, d.Ready())
+	assertEqual(t, 0, d.SamplesUntilReady())
+}
+
 // violate rule 1 : One point is more than 3 standard deviations from the mean
 // 9, 10, [ 18 ], 11
 func TestRule1(t *testing.T) {
@@ -297,6 +308,210 @@ func TestRule8(t *testing.T) {
 	assertEqual(t, 1, d.Violations[Rule8.Name])
 }
 
+// violate a tuned rule 2: four (or more) points in a row are on the same side of the mean
+// 9, [ 11, 11, 11, 11 ]
+func TestRuleParams(t *testing.T) {
+	rule2 := NewRule2(RuleParams{RunLength: 4})
+	assertEqual(t, 4, rule2.Params.RunLength)
+	assertEqual(t, 4, MaxSamples(rule2))
+	assertEqual(t, 15, MaxSamples(AllRules...))
+
+	rule5 := NewRule5(RuleParams{Sigma: 1.5})
+	assertEqual(t, 3, rule5.Params.WindowSize)
+	assertEqual(t, 2, rule5.Params.MinCount)
+
+	d := NewData("test-metric", 10, rule2)
+	d.AddSamples(statSamples)
+	assertEqual(t, true, d.stats.ready)
+
+	testSamples := []Sample{
+		testSample{200000, 9.0},
+		testSample{201000, 11.0},
+		testSample{202000, 11.0},
+		testSample{203000, 11.0},
+	}
+
+	d.AddSamples(testSamples)
+	assertEqual(t, false, d.hasViolations()) // not yet
+
+	testSamples = []Sample{
+		testSample{204000, 11.0},
+	}
+
+	d.AddSamples(testSamples)
+	assertEqual(t, 1, len(d.Violations))
+	assertEqual(t, 1, d.Violations[Rule2.Name])
+	assertEqual(t, 4, d.ViolationsData.Len())
+}
+
+// violate westgard R-4s: two points in a row span more than 4 standard deviations
+// 10, 4.5, [ 4.5, 15.5 ]
+func TestWestgardR4s(t *testing.T) {
+	rules, err := LookupRuleSet("westgard")
+	assertEqual(t, nil, err)
+	assertEqual(t, 10, MaxSamples(rules...))
+
+	d := NewData("test-metric", 10, WestgardR4s)
+	d.AddSamples(statSamples)
+	assertEqual(t, true, d.stats.ready)
+
+	testSamples := []Sample{
+		testSample{200000, 10.0},
+		testSample{201000, 4.5},
+		testSample{202000, 4.5},
+	}
+
+	d.AddSamples(testSamples)
+	assertEqual(t, false, d.hasViolations()) // not yet
+
+	testSamples = []Sample{
+		testSample{203000, 15.5},
+	}
+
+	d.AddSamples(testSamples)
+	assertEqual(t, 1, len(d.Violations))
+	assertEqual(t, 1, d.Violations[WestgardR4s.Name])
+}
+
+// violate western electric rule 3: At least 4 of 5 points in a row are in zone B or beyond.
+// [ 7, 7, 10, 7, 7 ]
+func TestWE3(t *testing.T) {
+	d := NewData("test-metric", 10, WesternElectricRules...)
+	d.AddSamples(statSamples)
+	assertEqual(t, true, d.stats.ready)
+
+	testSamples := []Sample{
+		testSample{200000, 7.0},
+		testSample{201000, 7.0},
+		testSample{202000, 10.0},
+		testSample{203000, 7.0},
+	}
+
+	d.AddSamples(testSamples)
+	assertEqual(t, false, d.hasViolations()) // not yet
+
+	testSamples = []Sample{
+		testSample{204000, 7.0},
+	}
+
+	d.AddSamples(testSamples)
+	assertEqual(t, 1, len(d.Violations))
+	assertEqual(t, 1, d.Violations[WE3.Name])
+
+	_, err := LookupRuleSet("bogus")
+	assertEqual(t, true, err != nil)
+}
+
+// violate a user-defined rule: three points in a row are zero
+// 9, 0, 0, 11, [ 0, 0, 0 ]
+func TestCustomRule(t *testing.T) {
+	zeros := NewRule("Zeros", "Three points in a row are zero.", RuleParams{RunLength: 3},
+		func(d *Data, p RuleParams, rs *RuleState, v float64) bool {
+			if v != 0.0 {
+				rs.Count = 0
+				return false
+			}
+			rs.Count++
+			rs.Values["last"] = float64(d.Recent(1)[0].Time())
+			return rs.Count >= p.RunLength
+		})
+	assertEqual(t, 3, MaxSamples(zeros))
+	assertEqual(t, nil, RegisterRuleSet("test-zeros", zeros))
+	assertEqual(t, true, RegisterRuleSet("test-zeros", zeros) != nil)
+
+	rules, err := LookupRuleSet("test-zeros")
+	assertEqual(t, nil, err)
+
+	d := NewData("test-metric", 10, rules...)
+	d.AddSamples(statSamples)
+	assertEqual(t, true, d.Ready())
+	assertEqual(t, "10.0", fmt.Sprintf("%.1f", d.Mean()))
+
+	testSamples := []Sample{
+		testSample{200000, 9.0},
+		testSample{201000, 0.0},
+		testSample{202000, 0.0},
+		testSample{203000, 11.0},
+		testSample{204000, 0.0},
+		testSample{205000, 0.0},
+	}
+
+	d.AddSamples(testSamples)
+	assertEqual(t, false, d.hasViolations()) // not yet
+	assertEqual(t, 205000.0, d.ruleStates["Zeros"].Values["last"])
+
+	testSamples = []Sample{
+		testSample{206000, 0.0},
+	}
+
+	d.AddSamples(testSamples)
+	assertEqual(t, 1, d.Violations["Zeros"])
+
+	d.Clear()
+	assertEqual(t, 0, d.ruleStates["Zeros"].Count)
+	assertEqual(t, 0, len(d.ruleStates["Zeros"].Values))
+}
+
+// AddSamples should process samples oldest first and report per-sample results
+// 9, 10, [ 18 ], 11 (supplied out of order)
+func TestAddSamples(t *testing.T) {
+	d := NewData("test-metric", 10)
+	results := d.AddSamples(statSamples)
+	assertEqual(t, 10, len(results))
+	assertEqual(t, false, results[9].Evaluated)
+
+	testSamples := []Sample{
+		testSample{203000, 11.0},
+		testSample{202000, 18.0},
+		testSample{200000, 9.0},
+		testSample{201000, 10.0},
+	}
+
+	results = d.AddSamples(testSamples)
+	assertEqual(t, 4, len(results))
+	assertEqual(t, int64(200000), results[0].Time)
+	assertEqual(t, int64(203000), results[3].Time)
+	assertEqual(t, true, results[0].Evaluated)
+	assertEqual(t, 0, len(results[0].Violations))
+	assertEqual(t, 18.0, results[2].Value)
+	assertEqual(t, 1, len(results[2].Violations))
+	assertEqual(t, Rule1.Name, results[2].Violations[0].Rule)
+	assertEqual(t, 0, len(results[3].Violations))
+	assertEqual(t, int64(203000), d.LastTime())
+
+	d.Clear()
+	assertEqual(t, int64(203000), d.LastTime())
+}
+
+// violate rule 3 with a run length of 3 : 9, 10, 11, [ 12 ]
+func TestViolation(t *testing.T) {
+	rule := NewRule3(RuleParams{RunLength: 3})
+	d := NewData("test-metric", 10, rule)
+	d.AddSamples(statSamples)
+
+	result := d.AddSample(testSample{200000, 9.0})
+	assertEqual(t, true, result.Evaluated)
+	assertEqual(t, 0, len(result.Violations))
+	d.AddSample(testSample{201000, 10.0})
+	d.AddSample(testSample{202000, 11.0})
+	result = d.AddSample(testSample{203000, 12.0})
+	assertEqual(t, true, result.Evaluated)
+	assertEqual(t, 1, len(result.Violations))
+
+	v := result.Violations[0]
+	assertEqual(t, rule.Name, v.Rule)
+	assertEqual(t, rule.Description, v.Description)
+	assertEqual(t, "test-metric", v.Metric)
+	assertEqual(t, int64(203000), v.Time)
+	assertEqual(t, 12.0, v.Value)
+	assertEqual(t, "10.0", fmt.Sprintf("%.1f", v.Mean))
+	assertEqual(t, "2.58199", fmt.Sprintf("%.5f", v.StdDev))
+	// the run, oldest first
+	assertEqual(t, 4, len(v.Window))
+	assertEqual(t, Point{200000, 9.0}, v.Window[0])
+	assertEqual(t, Point{203000, 12.0}, v.Window[3])
+}
+
 func assertEqual(t *testing.T, e interface{}, v interface{}) {
 	if reflect.TypeOf(e) != reflect.TypeOf(v) {
 		debug.PrintStack()
@@ -0,0 +1,410 @@
+// rules.go
+package nelson
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+)
+
+// RuleParams configures the sensitivity of a Rule. Each Rule uses only the
+// params relevant to its test, zero values are replaced with the Rule's defaults.
+type RuleParams struct {
+	// RunLength is the number of points (or moves) in a row required for a violation
+	RunLength int
+	// WindowSize is the number of most recent points considered
+	WindowSize int
+	// Sigma is the number of standard deviations from the mean bounding the test
+	Sigma float64
+	// MinCount is the number of points in the window required for a violation
+	MinCount int
+}
+
+func (p RuleParams) withDefaults(defaults RuleParams) RuleParams {
+	if p.RunLength == 0 {
+		p.RunLength = defaults.RunLength
+	}
+	if p.WindowSize == 0 {
+		p.WindowSize = defaults.WindowSize
+	}
+	if p.Sigma == 0.0 {
+		p.Sigma = defaults.Sigma
+	}
+	if p.MinCount == 0 {
+		p.MinCount = defaults.MinCount
+	}
+	return p
+}
+
+// RuleFunc evaluates v, the value of the newest Sample, for the time series tracked by d.
+// It returns true if the value violates the Rule. Baseline stats and recent Samples are
+// available via d, and the Rule's own state for the time series via rs. RuleFuncs are only
+// called after the baseline stats are ready.
+type RuleFunc func(d *Data, p RuleParams, rs *RuleState, v float64) bool
+
+type Rule struct {
+	Name        string
+	Description string
+	Params      RuleParams
+	f           RuleFunc
+	// number of Samples needed to evaluate the Rule
+	samples int
+}
+
+// NewRule returns a user-defined Rule. The Rule's name must not collide with the
+// other Rules in a rule set. The number of Samples needed to evaluate the Rule is
+// the larger of p.RunLength and p.WindowSize.
+func NewRule(name, description string, p RuleParams, f RuleFunc) Rule {
+	samples := 1
+	if p.RunLength > samples {
+		samples = p.RunLength
+	}
+	if p.WindowSize > samples {
+		samples = p.WindowSize
+	}
+
+	return Rule{
+		Name:        name,
+		Description: description,
+		Params:      p,
+		f:           f,
+		samples:     samples,
+	}
+}
+
+func (r Rule) String() string {
+	return r.Name
+}
+
+// RuleState is the state of a single Rule for a particular time series. It is reset by
+// Data.Clear(). The built-in Rules use Count, Previous, Direction and Window. Values is
+// free for use by user-defined Rules.
+type RuleState struct {
+	Count     int
+	Previous  *float64
+	Direction string
+	// List of direction Elements (">", "<" or "") for the most recent points
+	Window *list.List
+	Values map[string]float64
+}
+
+func newRuleState() *RuleState {
+	return &RuleState{
+		Window: list.New(),
+		Values: make(map[string]float64),
+	}
+}
+
+func (rs *RuleState) clear() {
+	rs.Count = 0
+	rs.Previous = nil
+	rs.Direction = ""
+	rs.Window.Init()
+	rs.Values = make(map[string]float64)
+}
+
+// The Nelson Rule defaults
+var (
+	rule1Defaults = RuleParams{Sigma: 3}
+	rule2Defaults = RuleParams{RunLength: 9}
+	rule3Defaults = RuleParams{RunLength: 6}
+	rule4Defaults = RuleParams{RunLength: 14}
+	rule5Defaults = RuleParams{WindowSize: 3, MinCount: 2, Sigma: 2}
+	rule6Defaults = RuleParams{WindowSize: 5, MinCount: 4, Sigma: 1}
+	rule7Defaults = RuleParams{RunLength: 15, Sigma: 1}
+	rule8Defaults = RuleParams{RunLength: 8, Sigma: 1}
+)
+
+// NewRule1 returns Rule1 configured with p.Sigma.
+func NewRule1(p RuleParams) Rule {
+	p = p.withDefaults(rule1Defaults)
+	return Rule{
+		Name:        "Rule1",
+		Description: fmt.Sprintf("One point is more than %v standard deviations from the mean.", p.Sigma),
+		Params:      p,
+		f:           (*Data).rule1,
+		samples:     1,
+	}
+}
+
+// NewRule2 returns Rule2 configured with p.RunLength.
+func NewRule2(p RuleParams) Rule {
+	p = p.withDefaults(rule2Defaults)
+	return Rule{
+		Name:        "Rule2",
+		Description: fmt.Sprintf("%v (or more) points in a row are on the same side of the mean.", p.RunLength),
+		Params:      p,
+		f:           (*Data).rule2,
+		samples:     p.RunLength,
+	}
+}
+
+// NewRule3 returns Rule3 configured with p.RunLength.
+func NewRule3(p RuleParams) Rule {
+	p = p.withDefaults(rule3Defaults)
+	return Rule{
+		Name:        "Rule3",
+		Description: fmt.Sprintf("%v (or more) points in a row are continually increasing (or decreasing).", p.RunLength),
+		Params:      p,
+		f:           (*Data).rule3,
+		samples:     p.RunLength + 1,
+	}
+}
+
+// NewRule4 returns Rule4 configured with p.RunLength.
+func NewRule4(p RuleParams) Rule {
+	p = p.withDefaults(rule4Defaults)
+	return Rule{
+		Name:        "Rule4",
+		Description: fmt.Sprintf("%v (or more) points in a row alternate in direction, increasing then decreasing.", p.RunLength),
+		Params:      p,
+		f:           (*Data).rule4,
+		samples:     p.RunLength + 1,
+	}
+}
+
+// NewRule5 returns Rule5 configured with p.MinCount, p.WindowSize and p.Sigma.
+func NewRule5(p RuleParams) Rule {
+	p = p.withDefaults(rule5Defaults)
+	return Rule{
+		Name:        "Rule5",
+		Description: fmt.Sprintf("At least %v of %v points in a row are > %v standard deviations from the mean in the same direction.", p.MinCount, p.WindowSize, p.Sigma),
+		Params:      p,
+		f:           (*Data).rule5,
+		samples:     p.WindowSize,
+	}
+}
+
+// NewRule6 returns Rule6 configured with p.MinCount, p.WindowSize and p.Sigma.
+func NewRule6(p RuleParams) Rule {
+	p = p.withDefaults(rule6Defaults)
+	return Rule{
+		Name:        "Rule6",
+		Description: fmt.Sprintf("At least %v of %v points in a row are > %v standard deviation from the mean in the same direction.", p.MinCount, p.WindowSize, p.Sigma),
+		Params:      p,
+		f:           (*Data).rule6,
+		samples:     p.WindowSize,
+	}
+}
+
+// NewRule7 returns Rule7 configured with p.RunLength and p.Sigma.
+func NewRule7(p RuleParams) Rule {
+	p = p.withDefaults(rule7Defaults)
+	return Rule{
+		Name:        "Rule7",
+		Description: fmt.Sprintf("%v points in a row are all within %v standard deviation of the mean on either side of the mean.", p.RunLength, p.Sigma),
+		Params:      p,
+		f:           (*Data).rule7,
+		samples:     p.RunLength,
+	}
+}
+
+// NewRule8 returns Rule8 configured with p.RunLength and p.Sigma.
+func NewRule8(p RuleParams) Rule {
+	p = p.withDefaults(rule8Defaults)
+	return Rule{
+		Name:        "Rule8",
+		Description: fmt.Sprintf("%v points in a row exist, but none within %v standard deviation of the mean and the points are in both directions from the mean.", p.RunLength, p.Sigma),
+		Params:      p,
+		f:           (*Data).rule8,
+		samples:     p.RunLength,
+	}
+}
+
+// The Nelson Rules, with default params
+var (
+	Rule1 = NewRule1(RuleParams{})
+	Rule2 = NewRule2(RuleParams{})
+	Rule3 = NewRule3(RuleParams{})
+	Rule4 = NewRule4(RuleParams{})
+	Rule5 = NewRule5(RuleParams{})
+	Rule6 = NewRule6(RuleParams{})
+	Rule7 = NewRule7(RuleParams{})
+	Rule8 = NewRule8(RuleParams{})
+)
+
+// CommonRules includes all rules other than: Rule7
+var CommonRules = []Rule{Rule1, Rule2, Rule3, Rule4, Rule5, Rule6, Rule8}
+
+// AllRules is not recommended for metrics with little to no variance when well-behaved
+var AllRules = []Rule{Rule1, Rule2, Rule3, Rule4, Rule5, Rule6, Rule7, Rule8}
+
+// MaxSamples returns the max number of Samples needed to evaluate any of the Rules.
+// With default params Rule4 and Rule7 require the most Samples, 15.
+func MaxSamples(rules ...Rule) int {
+	max := 1
+	for _, r := range rules {
+		if r.samples > max {
+			max = r.samples
+		}
+	}
+	return max
+}
+
+// one point is more than [3] standard deviations from the mean
+func (d *Data) rule1(p RuleParams, rs *RuleState, s float64) bool {
+	if d.stats.standardDeviation == 0.0 {
+		return false
+	}
+
+	return math.Abs(s-d.stats.mean) > p.Sigma*d.stats.standardDeviation
+}
+
+// Nine [or more] points in a row are on the same side of the mean
+func (d *Data) rule2(p RuleParams, rs *RuleState, s float64) bool {
+	switch {
+	case s > d.stats.mean:
+		if rs.Count > 0 {
+			rs.Count++
+		} else {
+			rs.Count = 1
+		}
+	case s < d.stats.mean:
+		if rs.Count < 0 {
+			rs.Count--
+		} else {
+			rs.Count = -1
+		}
+	default:
+		rs.Count = 0
+	}
+
+	return math.Abs(float64(rs.Count)) >= float64(p.RunLength)
+}
+
+// Six [or more] points in a row are continually increasing (or decreasing)
+func (d *Data) rule3(p RuleParams, rs *RuleState, s float64) bool {
+	if nil == rs.Previous {
+		rs.Previous = &s
+		rs.Count = 0
+		return false
+	}
+
+	if s > *rs.Previous {
+		if rs.Count > 0 {
+			rs.Count++
+		} else {
+			rs.Count = 1
+		}
+	} else if s < *rs.Previous {
+		if rs.Count < 0 {
+			rs.Count--
+		} else {
+			rs.Count = -1
+		}
+	} else {
+		rs.Count = 0
+	}
+
+	*rs.Previous = s
+
+	return math.Abs(float64(rs.Count)) >= float64(p.RunLength)
+}
+
+// Fourteen [or more] points in a row alternate in direction, increasing then decreasing
+func (d *Data) rule4(p RuleParams, rs *RuleState, s float64) bool {
+	if nil == rs.Previous || s == *rs.Previous {
+		rs.Previous = &s
+		rs.Direction = "="
+		rs.Count = 0
+		return false
+	}
+
+	sampleDirection := ">"
+	if s <= *rs.Previous {
+		sampleDirection = "<"
+	}
+
+	if sampleDirection == rs.Direction {
+		rs.Count = 0
+	} else {
+		rs.Count++
+	}
+
+	*rs.Previous = s
+	rs.Direction = sampleDirection
+
+	return rs.Count >= p.RunLength
+}
+
+// At least [2] of [3] points in a row are > [2] standard deviations from the mean in the same direction
+func (d *Data) rule5(p RuleParams, rs *RuleState, s float64) bool {
+	return d.beyondInWindow(p, rs, s)
+}
+
+// At least [4] of [5] points in a row are > [1] standard deviation from the mean in the same direction
+func (d *Data) rule6(p RuleParams, rs *RuleState, s float64) bool {
+	return d.beyondInWindow(p, rs, s)
+}
+
+// beyondInWindow returns true if at least p.MinCount of the last p.WindowSize points are
+// more than p.Sigma standard deviations from the mean in the same direction.
+func (d *Data) beyondInWindow(p RuleParams, rs *RuleState, s float64) bool {
+	if d.stats.standardDeviation == 0.0 {
+		return false
+	}
+
+	if math.Abs(s-d.stats.mean) > p.Sigma*d.stats.standardDeviation {
+		if s > d.stats.mean {
+			rs.Window.PushFront(">")
+		} else {
+			rs.Window.PushFront("<")
+		}
+	} else {
+		rs.Window.PushFront("")
+	}
+
+	if rs.Window.Len() > p.WindowSize {
+		rs.Window.Remove(rs.Window.Back())
+	}
+
+	var above, below int
+	for e := rs.Window.Front(); e != nil; e = e.Next() {
+		switch e.Value.(string) {
+		case ">":
+			above++
+		case "<":
+			below++
+		}
+	}
+
+	return above >= p.MinCount || below >= p.MinCount
+}
+
+// Fifteen points in a row are all within [1] standard deviation of the mean on either side of the mean
+// Note: I have my doubts about this one wrt monitored metrics, i think it may not be uncommon to have
+// a very steady metric. Minimally, I have taken away the flat-line case where all samples are the mean.
+func (d *Data) rule7(p RuleParams, rs *RuleState, s float64) bool {
+	if d.stats.standardDeviation == 0.0 {
+		return false
+	}
+
+	if s == d.stats.mean {
+		rs.Count = 0
+		return false
+	}
+
+	if math.Abs(s-d.stats.mean) <= p.Sigma*d.stats.standardDeviation {
+		rs.Count++
+	} else {
+		rs.Count = 0
+	}
+
+	return rs.Count >= p.RunLength
+}
+
+// Eight points in a row exist, but none within [1] standard deviation of the mean
+// and the points are in both directions from the mean
+func (d *Data) rule8(p RuleParams, rs *RuleState, s float64) bool {
+	if d.stats.standardDeviation == 0.0 {
+		return false
+	}
+
+	if math.Abs(s-d.stats.mean) > p.Sigma*d.stats.standardDeviation {
+		rs.Count++
+	} else {
+		rs.Count = 0
+	}
+
+	return rs.Count >= p.RunLength
+}
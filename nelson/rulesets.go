@@ -0,0 +1,163 @@
+// rulesets.go
+package nelson
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// The Western Electric Rules test for points in the zones around the mean:
+// Zone A is beyond 2 standard deviations, Zone B beyond 1 and Zone C within 1.
+var (
+	WE1 = Rule{
+		Name:        "WE1",
+		Description: "One point is beyond Zone A (more than 3 standard deviations from the mean).",
+		Params:      RuleParams{Sigma: 3},
+		f:           (*Data).rule1,
+		samples:     1,
+	}
+	WE2 = Rule{
+		Name:        "WE2",
+		Description: "At least 2 of 3 points in a row are in Zone A or beyond, on the same side of the mean.",
+		Params:      RuleParams{WindowSize: 3, MinCount: 2, Sigma: 2},
+		f:           (*Data).beyondInWindow,
+		samples:     3,
+	}
+	WE3 = Rule{
+		Name:        "WE3",
+		Description: "At least 4 of 5 points in a row are in Zone B or beyond, on the same side of the mean.",
+		Params:      RuleParams{WindowSize: 5, MinCount: 4, Sigma: 1},
+		f:           (*Data).beyondInWindow,
+		samples:     5,
+	}
+	WE4 = Rule{
+		Name:        "WE4",
+		Description: "Eight points in a row are in Zone C or beyond, on the same side of the mean.",
+		Params:      RuleParams{RunLength: 8},
+		f:           (*Data).rule2,
+		samples:     8,
+	}
+)
+
+// The Westgard Rules, named using the conventional multirule notation.
+var (
+	Westgard12s = Rule{
+		Name:        "1-2s",
+		Description: "One point is more than 2 standard deviations from the mean (warning).",
+		Params:      RuleParams{Sigma: 2},
+		f:           (*Data).rule1,
+		samples:     1,
+	}
+	Westgard13s = Rule{
+		Name:        "1-3s",
+		Description: "One point is more than 3 standard deviations from the mean.",
+		Params:      RuleParams{Sigma: 3},
+		f:           (*Data).rule1,
+		samples:     1,
+	}
+	Westgard22s = Rule{
+		Name:        "2-2s",
+		Description: "Two points in a row are more than 2 standard deviations from the mean on the same side of the mean.",
+		Params:      RuleParams{WindowSize: 2, MinCount: 2, Sigma: 2},
+		f:           (*Data).beyondInWindow,
+		samples:     2,
+	}
+	WestgardR4s = Rule{
+		Name:        "R-4s",
+		Description: "Two points in a row span more than 4 standard deviations, one above +2 and the other below -2 standard deviations.",
+		Params:      RuleParams{Sigma: 4},
+		f:           (*Data).rangeBeyond,
+		samples:     2,
+	}
+	Westgard41s = Rule{
+		Name:        "4-1s",
+		Description: "Four points in a row are more than 1 standard deviation from the mean on the same side of the mean.",
+		Params:      RuleParams{WindowSize: 4, MinCount: 4, Sigma: 1},
+		f:           (*Data).beyondInWindow,
+		samples:     4,
+	}
+	Westgard10x = Rule{
+		Name:        "10x",
+		Description: "Ten points in a row are on the same side of the mean.",
+		Params:      RuleParams{RunLength: 10},
+		f:           (*Data).rule2,
+		samples:     10,
+	}
+)
+
+// WesternElectricRules are the classic Western Electric zone tests
+var WesternElectricRules = []Rule{WE1, WE2, WE3, WE4}
+
+// WestgardRules are the Westgard multirules, including the 1-2s warning rule
+var WestgardRules = []Rule{Westgard12s, Westgard13s, Westgard22s, WestgardR4s, Westgard41s, Westgard10x}
+
+// RuleSets are the predefined rule sets, key=rule set name
+var RuleSets = map[string][]Rule{
+	"common":           CommonRules,
+	"all":              AllRules,
+	"western-electric": WesternElectricRules,
+	"westgard":         WestgardRules,
+}
+
+// LookupRuleSet returns the predefined rule set with the given name.
+func LookupRuleSet(name string) ([]Rule, error) {
+	rules, ok := RuleSets[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown rule set [%s], valid rule sets: %v", name, RuleSetNames())
+	}
+	return rules, nil
+}
+
+// RegisterRuleSet adds a named rule set, typically including user-defined Rules, making it
+// available to LookupRuleSet. Registering an existing name, or Rules with duplicate names, is
+// an error. RegisterRuleSet is not safe for concurrent use with LookupRuleSet.
+func RegisterRuleSet(name string, rules ...Rule) error {
+	if _, ok := RuleSets[name]; ok {
+		return fmt.Errorf("Rule set [%s] is already registered", name)
+	}
+	if len(rules) == 0 {
+		return fmt.Errorf("Rule set [%s] has no rules", name)
+	}
+	names := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		if names[r.Name] {
+			return fmt.Errorf("Rule set [%s] has duplicate rule [%s]", name, r.Name)
+		}
+		names[r.Name] = true
+	}
+
+	RuleSets[name] = rules
+	return nil
+}
+
+// RuleSetNames returns the sorted names of the predefined rule sets.
+func RuleSetNames() []string {
+	names := make([]string, 0, len(RuleSets))
+	for name := range RuleSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Two points in a row span more than [4] standard deviations, on opposite sides of the mean
+// and each more than half of that from the mean.
+func (d *Data) rangeBeyond(p RuleParams, rs *RuleState, s float64) bool {
+	if d.stats.standardDeviation == 0.0 {
+		return false
+	}
+
+	if nil == rs.Previous {
+		rs.Previous = &s
+		return false
+	}
+
+	previous := *rs.Previous
+	*rs.Previous = s
+
+	half := p.Sigma * d.stats.standardDeviation / 2
+	low, high := math.Min(previous, s), math.Max(previous, s)
+
+	return low < d.stats.mean-half && high > d.stats.mean+half
+}
@@ -0,0 +1,57 @@
+// seasonality.go
+package nelson
+
+import (
+	"fmt"
+	"time"
+)
+
+// Seasonality determines whether Samples are evaluated against a single baseline, or
+// against a separate baseline for each seasonal bucket, chosen from Sample.Time(). Each
+// bucket establishes its own baseline stats from its own Samples.
+type Seasonality int
+
+const (
+	// NoSeasonality uses a single baseline
+	NoSeasonality Seasonality = iota
+	// HourOfDay uses 24 baselines, one for each hour of the day
+	HourOfDay
+	// DayOfWeek uses 7 baselines, one for each day of the week
+	DayOfWeek
+	// HourOfWeek uses 168 baselines, one for each hour of each day of the week
+	HourOfWeek
+)
+
+var seasonalityNames = []string{"none", "hour-of-day", "day-of-week", "hour-of-week"}
+
+func (s Seasonality) String() string {
+	if s < 0 || int(s) >= len(seasonalityNames) {
+		return fmt.Sprintf("Seasonality(%d)", s)
+	}
+	return seasonalityNames[s]
+}
+
+// ParseSeasonality returns the Seasonality for name, one of: none, hour-of-day, day-of-week, hour-of-week.
+func ParseSeasonality(name string) (Seasonality, error) {
+	for i, n := range seasonalityNames {
+		if n == name {
+			return Seasonality(i), nil
+		}
+	}
+	return NoSeasonality, fmt.Errorf("Unknown seasonality [%s], valid seasonalities: %v", name, seasonalityNames)
+}
+
+// bucket returns the seasonal bucket for t, a unix time in ms, in loc
+func (s Seasonality) bucket(t int64, loc *time.Location) int {
+	tm := time.Unix(0, t*int64(time.Millisecond)).In(loc)
+	switch s {
+	case HourOfDay:
+		return tm.Hour()
+	case DayOfWeek:
+		return int(tm.Weekday())
+	case HourOfWeek:
+		return int(tm.Weekday())*24 + tm.Hour()
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,258 @@
+// snapshot.go
+package nelson
+
+import (
+	"fmt"
+)
+
+// Point is a simple Sample implementation, used to serialize Samples.
+type Point struct {
+	T int64   `json:"t"` // unix time in ms
+	V float64 `json:"v"`
+}
+
+func (p Point) Time() int64 {
+	return p.T
+}
+
+func (p Point) Val() float64 {
+	return p.V
+}
+
+// Snapshot is the serializable state of a Data, excluding its Metric and configuration
+// (Options, including Rules). It can be restored into a Data with the same configuration.
+type Snapshot struct {
+	SampleSize int `json:"sampleSize"`
+	// Seasonality of the baseline stats, empty for NoSeasonality
+	Seasonality string         `json:"seasonality,omitempty"`
+	LastTime    int64          `json:"lastTime"`
+	Violations  map[string]int `json:"violations"`
+	// ViolationsData, newest first
+	ViolationsData []Point                      `json:"violationsData"`
+	Stats          *StatsSnapshot               `json:"stats,omitempty"`
+	SeasonalStats  map[int]StatsSnapshot        `json:"seasonalStats,omitempty"`
+	RuleStates     map[string]RuleStateSnapshot `json:"ruleStates"`
+	// AlertStates, for Rules not Inactive
+	AlertStates map[string]AlertStateSnapshot `json:"alertStates,omitempty"`
+}
+
+// StatsSnapshot is the serializable state of the baseline stats.
+type StatsSnapshot struct {
+	Ready bool `json:"ready"`
+	// recent values, oldest first
+	Values            []float64 `json:"values"`
+	SinceBaseline     int       `json:"sinceBaseline"`
+	BaselineTime      int64     `json:"baselineTime"`
+	Mean              float64   `json:"mean"`
+	Variance          float64   `json:"variance"`
+	StandardDeviation float64   `json:"standardDeviation"`
+}
+
+// RuleStateSnapshot is the serializable state of a RuleState.
+type RuleStateSnapshot struct {
+	Count     int      `json:"count"`
+	Previous  *float64 `json:"previous,omitempty"`
+	Direction string   `json:"direction,omitempty"`
+	// Window Elements, front first
+	Window []string           `json:"window,omitempty"`
+	Values map[string]float64 `json:"values,omitempty"`
+}
+
+// AlertStateSnapshot is the serializable state of a Rule's AlertState.
+type AlertStateSnapshot struct {
+	State     string     `json:"state"`
+	ActiveAt  int64      `json:"activeAt"`
+	Clean     int        `json:"clean"`
+	Violation *Violation `json:"violation,omitempty"`
+}
+
+// Snapshot returns the current state of d. It fails if a RuleState Window holds anything
+// but strings, which can't be serialized.
+func (d *Data) Snapshot() (Snapshot, error) {
+	snapshot := Snapshot{
+		SampleSize:     d.options.SampleSize,
+		LastTime:       d.lastTime,
+		Violations:     make(map[string]int, len(d.Violations)),
+		ViolationsData: make([]Point, 0, d.ViolationsData.Len()),
+		RuleStates:     make(map[string]RuleStateSnapshot, len(d.ruleStates)),
+	}
+	if d.options.Seasonality != NoSeasonality {
+		snapshot.Seasonality = d.options.Seasonality.String()
+	}
+
+	for k, v := range d.Violations {
+		snapshot.Violations[k] = v
+	}
+	for e := d.ViolationsData.Front(); e != nil; e = e.Next() {
+		s := e.Value.(Sample)
+		snapshot.ViolationsData = append(snapshot.ViolationsData, Point{T: s.Time(), V: s.Val()})
+	}
+
+	if d.options.Seasonality == NoSeasonality {
+		stats := d.stats.snapshot()
+		snapshot.Stats = &stats
+	} else {
+		snapshot.SeasonalStats = make(map[int]StatsSnapshot, len(d.seasonalStats))
+		for k, v := range d.seasonalStats {
+			snapshot.SeasonalStats[k] = v.snapshot()
+		}
+	}
+
+	for k, rs := range d.ruleStates {
+		rss := RuleStateSnapshot{
+			Count:     rs.Count,
+			Direction: rs.Direction,
+			Values:    make(map[string]float64, len(rs.Values)),
+		}
+		if nil != rs.Previous {
+			previous := *rs.Previous
+			rss.Previous = &previous
+		}
+		for e := rs.Window.Front(); e != nil; e = e.Next() {
+			w, ok := e.Value.(string)
+			if !ok {
+				return Snapshot{}, fmt.Errorf("Rule [%s] window element [%v] is a %T, only strings are supported", k, e.Value, e.Value)
+			}
+			rss.Window = append(rss.Window, w)
+		}
+		for vk, vv := range rs.Values {
+			rss.Values[vk] = vv
+		}
+		snapshot.RuleStates[k] = rss
+	}
+
+	for k, as := range d.alertStates {
+		if as.state == Inactive && nil == as.violation {
+			continue
+		}
+		if nil == snapshot.AlertStates {
+			snapshot.AlertStates = make(map[string]AlertStateSnapshot)
+		}
+		snapshot.AlertStates[k] = AlertStateSnapshot{
+			State:     as.state.String(),
+			ActiveAt:  as.activeAt,
+			Clean:     as.clean,
+			Violation: as.violation,
+		}
+	}
+
+	return snapshot, nil
+}
+
+// Restore replaces the state of d with the snapshot. The snapshot must have been taken
+// from a Data with the same sample size and Seasonality. State for Rules not in d.Rules is ignored, and
+// Rules not in the snapshot start with cleared state.
+func (d *Data) Restore(snapshot Snapshot) error {
+	if snapshot.SampleSize != d.options.SampleSize {
+		return fmt.Errorf("Snapshot sample size [%v] does not match [%v]", snapshot.SampleSize, d.options.SampleSize)
+	}
+	seasonality := snapshot.Seasonality
+	if seasonality == "" {
+		seasonality = NoSeasonality.String()
+	}
+	if seasonality != d.options.Seasonality.String() {
+		return fmt.Errorf("Snapshot seasonality [%v] does not match [%v]", seasonality, d.options.Seasonality)
+	}
+
+	d.Clear()
+	d.lastTime = snapshot.LastTime
+	if d.options.Seasonality != NoSeasonality && d.lastTime > 0 {
+		// the restored Rule state belongs to the bucket of the newest Sample
+		d.bucket = d.options.Seasonality.bucket(d.lastTime, d.options.Location)
+	}
+
+	for k, v := range snapshot.Violations {
+		d.Violations[k] = v
+	}
+	for _, p := range snapshot.ViolationsData {
+		if d.ViolationsData.Len() == d.maxSamples {
+			break
+		}
+		d.ViolationsData.PushBack(p)
+	}
+
+	if nil != snapshot.Stats {
+		d.stats.restore(*snapshot.Stats)
+	}
+	for k, v := range snapshot.SeasonalStats {
+		stats := d.options.newStatistics()
+		stats.restore(v)
+		d.seasonalStats[k] = stats
+	}
+	if stats, ok := d.seasonalStats[d.bucket]; ok {
+		// the current stats are those of the bucket of the newest Sample
+		d.stats = stats
+	}
+
+	for k, rs := range d.ruleStates {
+		rss, ok := snapshot.RuleStates[k]
+		if !ok {
+			continue
+		}
+		rs.Count = rss.Count
+		rs.Direction = rss.Direction
+		if nil != rss.Previous {
+			previous := *rss.Previous
+			rs.Previous = &previous
+		}
+		for _, w := range rss.Window {
+			rs.Window.PushBack(w)
+		}
+		for vk, vv := range rss.Values {
+			rs.Values[vk] = vv
+		}
+	}
+
+	for k, as := range d.alertStates {
+		ass, ok := snapshot.AlertStates[k]
+		if !ok {
+			continue
+		}
+		for i, n := range alertStateNames {
+			if n == ass.State {
+				as.state = AlertState(i)
+			}
+		}
+		as.activeAt = ass.ActiveAt
+		as.clean = ass.Clean
+		if nil != ass.Violation {
+			violation := *ass.Violation
+			// the Metric does not survive serialization intact
+			violation.Metric = d.Metric
+			as.violation = &violation
+		}
+	}
+
+	return nil
+}
+
+func (s *statistics) snapshot() StatsSnapshot {
+	values := s.recent(s.numSamples)
+	// recent is newest first
+	for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+		values[i], values[j] = values[j], values[i]
+	}
+
+	return StatsSnapshot{
+		Ready:             s.ready,
+		Values:            values,
+		SinceBaseline:     s.sinceBaseline,
+		BaselineTime:      s.baselineTime,
+		Mean:              s.mean,
+		Variance:          s.variance,
+		StandardDeviation: s.standardDeviation,
+	}
+}
+
+func (s *statistics) restore(snapshot StatsSnapshot) {
+	s.clear()
+	for _, v := range snapshot.Values {
+		s.push(v)
+	}
+	s.ready = snapshot.Ready
+	s.sinceBaseline = snapshot.SinceBaseline
+	s.baselineTime = snapshot.BaselineTime
+	s.mean = snapshot.Mean
+	s.variance = snapshot.Variance
+	s.standardDeviation = snapshot.StandardDeviation
+}
@@ -0,0 +1,116 @@
+// snapshot_test.go
+package nelson
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// violate rule 4 across a snapshot and restore
+// [ 9.5, 12.6, 9.5, 10.5, 9.5, 10.5, 9.5, 10.5 ] restore [ 9.5, 10.5, 9.5, 10.5, 9.5, 10.5, 9.8 ]
+func TestSnapshotRestore(t *testing.T) {
+	d := NewData("test-metric", 10)
+	d.AddSamples(statSamples)
+	assertEqual(t, true, d.stats.ready)
+
+	testSamples := []Sample{
+		testSample{200000, 9.5},
+		testSample{201000, 12.6},
+		testSample{202000, 9.5},
+		testSample{203000, 10.5},
+		testSample{204000, 9.5},
+		testSample{205000, 10.5},
+		testSample{206000, 9.5},
+		testSample{207000, 10.5},
+	}
+
+	d.AddSamples(testSamples)
+	assertEqual(t, false, d.hasViolations()) // not yet
+
+	snapshot, err := d.Snapshot()
+	assertEqual(t, nil, err)
+	bytes, err := json.Marshal(snapshot)
+	assertEqual(t, nil, err)
+
+	snapshot = Snapshot{}
+	assertEqual(t, nil, json.Unmarshal(bytes, &snapshot))
+
+	restored := NewData("test-metric", 10)
+	assertEqual(t, nil, restored.Restore(snapshot))
+	assertEqual(t, true, restored.stats.ready)
+	assertEqual(t, "10.0", fmt.Sprintf("%.1f", restored.stats.mean))
+	assertEqual(t, "2.58199", fmt.Sprintf("%.5f", restored.stats.standardDeviation))
+	assertEqual(t, 8, restored.ViolationsData.Len())
+	assertEqual(t, int64(207000), restored.ViolationsData.Front().Value.(Sample).Time())
+	assertEqual(t, int64(207000), restored.LastTime())
+
+	testSamples = []Sample{
+		testSample{208000, 9.5},
+		testSample{209000, 10.5},
+		testSample{210000, 9.5},
+		testSample{211000, 10.5},
+		testSample{212000, 9.5},
+		testSample{213000, 10.5},
+		testSample{214000, 9.8},
+	}
+
+	restored.AddSamples(testSamples)
+	assertEqual(t, 1, len(restored.Violations))
+	assertEqual(t, 1, restored.Violations[Rule4.Name])
+
+	mismatched := NewData("test-metric", 20)
+	assertEqual(t, true, mismatched.Restore(snapshot) != nil)
+}
+
+// a partially established seasonal baseline survives a restore
+func TestSnapshotRestoreSeasonal(t *testing.T) {
+	o := Options{SampleSize: 10, Seasonality: HourOfDay}
+	d := NewDataWithOptions("test-metric", o)
+	d.AddSamples(statSamples[:5])
+
+	snapshot, err := d.Snapshot()
+	assertEqual(t, nil, err)
+	restored := NewDataWithOptions("test-metric", o)
+	assertEqual(t, nil, restored.Restore(snapshot))
+	assertEqual(t, d.SamplesUntilReady(), restored.SamplesUntilReady())
+	assertEqual(t, d.Mean(), restored.Mean())
+	restored.AddSamples(statSamples[5:])
+	assertEqual(t, true, restored.Ready())
+	assertEqual(t, "10.0", fmt.Sprintf("%.1f", restored.stats.mean))
+
+	// the established baseline is reported before the next Sample
+	d.AddSamples(statSamples[5:])
+	snapshot, err = d.Snapshot()
+	assertEqual(t, nil, err)
+	restored = NewDataWithOptions("test-metric", o)
+	assertEqual(t, nil, restored.Restore(snapshot))
+	assertEqual(t, true, restored.Ready())
+	assertEqual(t, 0, restored.SamplesUntilReady())
+	assertEqual(t, d.Mean(), restored.Mean())
+	assertEqual(t, d.StdDev(), restored.StdDev())
+
+	// the seasonal stats can't be restored without seasonality, or vice versa
+	plain := NewData("test-metric", 10)
+	assertEqual(t, true, plain.Restore(snapshot) != nil)
+	snapshot, err = plain.Snapshot()
+	assertEqual(t, nil, err)
+	assertEqual(t, true, restored.Restore(snapshot) != nil)
+}
+
+// a user-defined rule keeping non-string window elements can't be snapshot
+func TestSnapshotWindow(t *testing.T) {
+	window := NewRule("Window", "Remembers values.", RuleParams{RunLength: 1},
+		func(d *Data, p RuleParams, rs *RuleState, v float64) bool {
+			rs.Window.PushBack(v)
+			return false
+		})
+	d := NewData("test-metric", 10, window)
+	d.AddSamples(statSamples)
+	_, err := d.Snapshot()
+	assertEqual(t, nil, err)
+
+	d.AddSample(testSample{200000, 9.0})
+	_, err = d.Snapshot()
+	assertEqual(t, true, err != nil)
+}
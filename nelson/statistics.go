@@ -0,0 +1,317 @@
+// statistics.go
+package nelson
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gonum/stat"
+)
+
+// BaselineMode determines how the baseline stats evolve after they are first
+// established from sampleSize samples.
+type BaselineMode int
+
+const (
+	// Frozen stats are never updated, later values are ignored
+	Frozen BaselineMode = iota
+	// Sliding stats are recalculated from the last Window values after every sample
+	Sliding
+	// EWMA stats are updated after every sample with an exponentially weighted mean and variance
+	EWMA
+	// Periodic stats are recalculated from the last sampleSize values every Period
+	Periodic
+)
+
+var baselineModeNames = []string{"frozen", "sliding", "ewma", "periodic"}
+
+func (m BaselineMode) String() string {
+	if m < 0 || int(m) >= len(baselineModeNames) {
+		return fmt.Sprintf("BaselineMode(%d)", m)
+	}
+	return baselineModeNames[m]
+}
+
+// ParseBaselineMode returns the BaselineMode for name, one of: frozen, sliding, ewma, periodic.
+func ParseBaselineMode(name string) (BaselineMode, error) {
+	for i, n := range baselineModeNames {
+		if n == name {
+			return BaselineMode(i), nil
+		}
+	}
+	return Frozen, fmt.Errorf("Unknown baseline mode [%s], valid modes: %v", name, baselineModeNames)
+}
+
+// Baseline configures the baseline stats. The zero value is a Frozen baseline.
+type Baseline struct {
+	Mode BaselineMode
+	// Window is the number of values used by Sliding, defaults to sampleSize
+	Window int
+	// Alpha is the EWMA smoothing factor in (0,1], defaults to 2/(sampleSize+1)
+	Alpha float64
+	// Period is the Periodic re-baseline interval, measured in Sample time. If
+	// not set the stats are re-baselined every sampleSize samples.
+	Period time.Duration
+}
+
+func (b Baseline) String() string {
+	switch b.Mode {
+	case Sliding:
+		return fmt.Sprintf("%v(window=%v)", b.Mode, b.Window)
+	case EWMA:
+		return fmt.Sprintf("%v(alpha=%.3f)", b.Mode, b.Alpha)
+	case Periodic:
+		if b.Period > 0 {
+			return fmt.Sprintf("%v(period=%v)", b.Mode, b.Period)
+		}
+		return fmt.Sprintf("%v(period=%v samples)", b.Mode, b.Window)
+	default:
+		return b.Mode.String()
+	}
+}
+
+// withDefaults returns the Baseline with unset fields defaulted for sampleSize
+func (b Baseline) withDefaults(sampleSize int) Baseline {
+	if b.Window <= 0 {
+		b.Window = sampleSize
+	}
+	if b.Alpha <= 0.0 || b.Alpha > 1.0 {
+		b.Alpha = 2.0 / float64(sampleSize+1)
+	}
+	return b
+}
+
+// Estimator determines how the baseline center (mean) and spread (standard deviation)
+// are calculated from the baseline values.
+type Estimator int
+
+const (
+	// Standard uses the mean and standard deviation
+	Standard Estimator = iota
+	// MedianMAD uses the median and the median absolute deviation, scaled to estimate
+	// the standard deviation of normally distributed data
+	MedianMAD
+	// Trimmed uses the mean of the values remaining after trimming Trim of the values
+	// from each end, and the standard deviation of the winsorized values
+	Trimmed
+	// Winsorized uses the mean and standard deviation of the values after replacing Trim
+	// of the values at each end with the nearest remaining value
+	Winsorized
+)
+
+// madScale converts MAD to a consistent estimator of the standard deviation for normal data
+const madScale = 1.4826
+
+// DefaultTrim is the fraction of values trimmed (or winsorized) from each end by default
+const DefaultTrim = 0.1
+
+var estimatorNames = []string{"standard", "mad", "trimmed", "winsorized"}
+
+func (e Estimator) String() string {
+	if e < 0 || int(e) >= len(estimatorNames) {
+		return fmt.Sprintf("Estimator(%d)", e)
+	}
+	return estimatorNames[e]
+}
+
+// ParseEstimator returns the Estimator for name, one of: standard, mad, trimmed, winsorized.
+func ParseEstimator(name string) (Estimator, error) {
+	for i, n := range estimatorNames {
+		if n == name {
+			return Estimator(i), nil
+		}
+	}
+	return Standard, fmt.Errorf("Unknown estimator [%s], valid estimators: %v", name, estimatorNames)
+}
+
+type statistics struct {
+	ready     bool
+	baseline  Baseline
+	estimator Estimator
+	// fraction of values trimmed from each end, for Trimmed and Winsorized
+	trim float64
+	// number of samples required to determine mean and stddev
+	sampleSize int
+	numSamples int
+	// ring buffer of the most recent values
+	values []float64
+	next   int
+	// for Periodic, samples and Sample time (ms) since the last baseline
+	sinceBaseline int
+	baselineTime  int64
+
+	mean              float64
+	variance          float64
+	standardDeviation float64
+}
+
+func (s statistics) String() string {
+	if !s.ready {
+		return fmt.Sprintf("Waiting on [%v] samples, baseline=%v, estimator=%v", s.samplesUntilReady(), s.baseline, s.estimator)
+	}
+	return fmt.Sprintf("baseline=%v, estimator=%v, mean=%.2f, stddev=%.2f", s.baseline, s.estimator, s.mean, s.standardDeviation)
+}
+
+// samplesUntilReady returns the number of values still needed to establish the baseline
+func (s *statistics) samplesUntilReady() int {
+	if s.ready {
+		return 0
+	}
+	return s.sampleSize - s.numSamples
+}
+
+func (s *statistics) bucketStats(bucket int) BucketStats {
+	return BucketStats{
+		Bucket:            bucket,
+		Ready:             s.ready,
+		SamplesUntilReady: s.samplesUntilReady(),
+		Mean:              s.mean,
+		StdDev:            s.standardDeviation,
+	}
+}
+
+func newStatistics(sampleSize int, baseline Baseline, estimator Estimator, trim float64) statistics {
+	baseline = baseline.withDefaults(sampleSize)
+	size := sampleSize
+	if baseline.Mode == Sliding && baseline.Window > size {
+		size = baseline.Window
+	}
+
+	if trim <= 0.0 || trim >= 0.5 {
+		trim = DefaultTrim
+	}
+
+	return statistics{
+		baseline:   baseline,
+		estimator:  estimator,
+		trim:       trim,
+		sampleSize: sampleSize,
+		values:     make([]float64, size),
+	}
+}
+
+func (s *statistics) clear() {
+	s.ready = false
+	s.numSamples = 0
+	s.values = make([]float64, len(s.values))
+	s.next = 0
+	s.sinceBaseline = 0
+	s.baselineTime = 0
+	s.mean = 0
+	s.variance = 0
+	s.standardDeviation = 0
+}
+
+// addSample returns true if stats are ready, false otherwise. Values added
+// after stats are ready update the stats as determined by the Baseline mode.
+func (s *statistics) addSample(sample Sample) bool {
+	if !s.ready {
+		s.push(sample.Val())
+		if s.numSamples == s.sampleSize {
+			s.calculate(s.sampleSize)
+			s.baselineTime = sample.Time()
+			s.ready = true
+		}
+		return s.ready
+	}
+
+	switch s.baseline.Mode {
+	case Sliding:
+		s.push(sample.Val())
+		s.calculate(s.baseline.Window)
+	case EWMA:
+		diff := sample.Val() - s.mean
+		incr := s.baseline.Alpha * diff
+		s.mean += incr
+		s.variance = (1 - s.baseline.Alpha) * (s.variance + diff*incr)
+		s.standardDeviation = math.Sqrt(s.variance)
+	case Periodic:
+		s.push(sample.Val())
+		s.sinceBaseline++
+		period := s.baseline.Period
+		if (period > 0 && sample.Time()-s.baselineTime >= int64(period/time.Millisecond)) ||
+			(period <= 0 && s.sinceBaseline >= s.baseline.Window) {
+			s.calculate(s.sampleSize)
+			s.baselineTime = sample.Time()
+			s.sinceBaseline = 0
+		}
+	}
+
+	return s.ready
+}
+
+// push adds v to the ring buffer of recent values
+func (s *statistics) push(v float64) {
+	s.values[s.next] = v
+	s.next = (s.next + 1) % len(s.values)
+	if s.numSamples < len(s.values) {
+		s.numSamples++
+	}
+}
+
+// recent returns up to n of the most recent values, newest first
+func (s *statistics) recent(n int) []float64 {
+	if n > s.numSamples {
+		n = s.numSamples
+	}
+	recent := make([]float64, n)
+	for i := 0; i < n; i++ {
+		recent[i] = s.values[(s.next-1-i+len(s.values))%len(s.values)]
+	}
+	return recent
+}
+
+// calculate sets the stats from the n most recent values, using the estimator
+func (s *statistics) calculate(n int) {
+	values := s.recent(n)
+
+	switch s.estimator {
+	case MedianMAD:
+		sort.Float64s(values)
+		s.mean = median(values)
+		deviations := make([]float64, len(values))
+		for i, v := range values {
+			deviations[i] = math.Abs(v - s.mean)
+		}
+		sort.Float64s(deviations)
+		s.standardDeviation = madScale * median(deviations)
+	case Trimmed:
+		sort.Float64s(values)
+		k := int(s.trim * float64(len(values)))
+		s.mean = stat.Mean(values[k:len(values)-k], nil)
+		s.standardDeviation = stat.StdDev(winsorize(values, k), nil)
+	case Winsorized:
+		sort.Float64s(values)
+		winsorized := winsorize(values, int(s.trim*float64(len(values))))
+		s.mean = stat.Mean(winsorized, nil)
+		s.standardDeviation = stat.StdDev(winsorized, nil)
+	default:
+		s.mean = stat.Mean(values, nil)
+		s.standardDeviation = stat.StdDev(values, nil)
+	}
+
+	s.variance = s.standardDeviation * s.standardDeviation
+}
+
+// median returns the median of sorted values
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// winsorize returns a copy of sorted values with the k values at each end replaced by
+// the nearest remaining value
+func winsorize(sorted []float64, k int) []float64 {
+	winsorized := make([]float64, len(sorted))
+	copy(winsorized, sorted)
+	for i := 0; i < k; i++ {
+		winsorized[i] = sorted[k]
+		winsorized[len(sorted)-1-i] = sorted[len(sorted)-1-k]
+	}
+	return winsorized
+}
@@ -0,0 +1,182 @@
+// statistics_test.go
+package nelson
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlidingBaseline(t *testing.T) {
+	d := NewDataWithOptions("test-metric", Options{SampleSize: 10, Baseline: Baseline{Mode: Sliding}})
+	d.AddSamples(statSamples)
+	assertEqual(t, true, d.stats.ready)
+	assertEqual(t, "10.0", fmt.Sprintf("%.1f", d.stats.mean))
+	assertEqual(t, true, strings.Contains(d.String(), "sliding(window=10)"))
+
+	testSamples := make([]Sample, 10)
+	for i := range testSamples {
+		testSamples[i] = testSample{int64(200000 + i*1000), 20.0}
+	}
+
+	d.AddSamples(testSamples)
+	assertEqual(t, "20.0", fmt.Sprintf("%.1f", d.stats.mean))
+	assertEqual(t, 0.0, d.stats.standardDeviation)
+}
+
+func TestEWMABaseline(t *testing.T) {
+	d := NewDataWithOptions("test-metric", Options{SampleSize: 10, Baseline: Baseline{Mode: EWMA, Alpha: 0.5}})
+	d.AddSamples(statSamples)
+	assertEqual(t, "10.0", fmt.Sprintf("%.1f", d.stats.mean))
+	assertEqual(t, true, strings.Contains(d.String(), "ewma(alpha=0.500)"))
+
+	d.AddSample(testSample{200000, 12.0})
+	assertEqual(t, "11.0", fmt.Sprintf("%.1f", d.stats.mean))
+}
+
+func TestPeriodicBaseline(t *testing.T) {
+	d := NewDataWithOptions("test-metric", Options{SampleSize: 10, Baseline: Baseline{Mode: Periodic, Period: 5 * time.Second}})
+	d.AddSamples(statSamples)
+	assertEqual(t, "10.0", fmt.Sprintf("%.1f", d.stats.mean))
+
+	testSamples := []Sample{
+		testSample{110000, 20.0},
+		testSample{111000, 20.0},
+		testSample{112000, 20.0},
+		testSample{113000, 20.0},
+	}
+
+	d.AddSamples(testSamples)
+	assertEqual(t, "10.0", fmt.Sprintf("%.1f", d.stats.mean)) // not yet
+
+	// re-baseline on the last 10 values: 10, 11, 12, 13, 14, 20, 20, 20, 20, 20
+	d.AddSample(testSample{114000, 20.0})
+	assertEqual(t, "16.0", fmt.Sprintf("%.1f", d.stats.mean))
+}
+
+func TestFrozenBaseline(t *testing.T) {
+	d := NewData("test-metric", 10)
+	d.AddSamples(statSamples)
+	assertEqual(t, true, strings.Contains(d.String(), "baseline=frozen"))
+
+	d.AddSample(testSample{200000, 20.0})
+	assertEqual(t, "10.0", fmt.Sprintf("%.1f", d.stats.mean))
+
+	d.Clear()
+	assertEqual(t, false, d.stats.ready)
+	assertEqual(t, false, d.AddSample(testSample{201000, 20.0}).Evaluated)
+
+	m, err := ParseBaselineMode("periodic")
+	assertEqual(t, nil, err)
+	assertEqual(t, Periodic, m)
+}
+
+// a single spike in the baseline should not hide a later rule 1 violation when using a robust estimator
+// 6, 7, 8, 9, 10, 10, 11, 12, 13, (100) : [ 20 ]
+func TestRobustEstimators(t *testing.T) {
+	spikeSamples := make([]Sample, len(statSamples))
+	copy(spikeSamples, statSamples)
+	spikeSamples[9] = testSample{109000, 100.0}
+
+	d := NewData("test-metric", 10, Rule1)
+	d.AddSamples(spikeSamples)
+	d.AddSample(testSample{200000, 20.0})
+	assertEqual(t, false, d.hasViolations())
+
+	d = NewDataWithOptions("test-metric", Options{SampleSize: 10, Rules: []Rule{Rule1}, Estimator: MedianMAD})
+	d.AddSamples(spikeSamples)
+	assertEqual(t, "10.0", fmt.Sprintf("%.1f", d.stats.mean))
+	assertEqual(t, "2.9652", fmt.Sprintf("%.4f", d.stats.standardDeviation))
+	assertEqual(t, true, strings.Contains(d.String(), "estimator=mad"))
+	d.AddSample(testSample{200000, 20.0})
+	assertEqual(t, 1, d.Violations[Rule1.Name])
+
+	d = NewDataWithOptions("test-metric", Options{SampleSize: 10, Rules: []Rule{Rule1}, Estimator: Trimmed})
+	d.AddSamples(spikeSamples)
+	assertEqual(t, "10.0", fmt.Sprintf("%.1f", d.stats.mean))
+	d.AddSample(testSample{200000, 20.0})
+	assertEqual(t, 1, d.Violations[Rule1.Name])
+
+	d = NewDataWithOptions("test-metric", Options{SampleSize: 10, Rules: []Rule{Rule1}, Estimator: Winsorized})
+	d.AddSamples(spikeSamples)
+	assertEqual(t, "10.0", fmt.Sprintf("%.1f", d.stats.mean))
+	d.AddSample(testSample{200000, 20.0})
+	assertEqual(t, 1, d.Violations[Rule1.Name])
+
+	e, err := ParseEstimator("winsorized")
+	assertEqual(t, nil, err)
+	assertEqual(t, Winsorized, e)
+}
+
+// hour 0 has mean=10, hour 1 has mean=100, each sample is evaluated against its own hour
+func TestSeasonality(t *testing.T) {
+	d := NewDataWithOptions("test-metric", Options{SampleSize: 10, Rules: []Rule{Rule1}, Seasonality: HourOfDay})
+	d.AddSamples(statSamples)
+	assertEqual(t, true, d.Ready())
+
+	hour := int64(time.Hour / time.Millisecond)
+	hourSamples := make([]Sample, len(statSamples))
+	for i, s := range statSamples {
+		hourSamples[i] = testSample{hour + s.Time(), s.Val() + 90.0}
+	}
+
+	results := d.AddSamples(hourSamples)
+	assertEqual(t, false, results[0].Evaluated)
+	assertEqual(t, false, results[9].Evaluated)
+	assertEqual(t, true, d.Ready())
+	assertEqual(t, "100.0", fmt.Sprintf("%.1f", d.stats.mean))
+	assertEqual(t, true, strings.Contains(d.String(), "seasonality=hour-of-day(2 buckets)"))
+
+	d.AddSample(testSample{hour + 200000, 101.0})
+	assertEqual(t, false, d.hasViolations())
+
+	d.AddSample(testSample{2*24*hour + 200000, 101.0}) // hour 0, two days later
+	assertEqual(t, "10.0", fmt.Sprintf("%.1f", d.stats.mean))
+	assertEqual(t, 1, d.Violations[Rule1.Name])
+
+	s, err := ParseSeasonality("hour-of-week")
+	assertEqual(t, nil, err)
+	assertEqual(t, HourOfWeek, s)
+	assertEqual(t, 25, HourOfWeek.bucket(4*24*hour+hour, time.UTC)) // Mon Jan 5 1970, 01:00
+}
+
+// a run of Samples spanning two buckets is not counted against either baseline, but a run
+// within a bucket is
+func TestSeasonalityRuleState(t *testing.T) {
+	d := NewDataWithOptions("test-metric", Options{SampleSize: 10, Rules: []Rule{Rule2}, Seasonality: HourOfDay})
+	hour := int64(time.Hour / time.Millisecond)
+	day := 24 * hour
+	for _, offset := range []int64{0, hour} {
+		for _, s := range statSamples {
+			d.AddSample(testSample{offset + s.Time(), s.Val()})
+		}
+	}
+	buckets := d.Buckets()
+	assertEqual(t, 2, len(buckets))
+	assertEqual(t, 1, buckets[1].Bucket)
+	assertEqual(t, true, buckets[0].Ready && buckets[1].Ready)
+	assertEqual(t, "10.0", fmt.Sprintf("%.1f", buckets[1].Mean))
+
+	// 5 above the mean at the end of hour 0, then 5 at the start of hour 1
+	for i := int64(0); i < 5; i++ {
+		d.AddSample(testSample{day + hour - (5-i)*1000, 12.0})
+	}
+	for i := int64(0); i < 5; i++ {
+		d.AddSample(testSample{day + hour + i*1000, 12.0})
+	}
+	assertEqual(t, false, d.hasViolations())
+	assertEqual(t, 5, d.ViolationsData.Len())
+
+	for i := int64(5); i < 9; i++ {
+		d.AddSample(testSample{day + hour + i*1000, 12.0})
+	}
+	assertEqual(t, 1, d.Violations[Rule2.Name])
+
+	// a new bucket is not Ready until it has its own baseline
+	d.AddSample(testSample{day + 2*hour, 12.0})
+	assertEqual(t, false, d.Ready())
+	assertEqual(t, 9, d.SamplesUntilReady())
+	assertEqual(t, 3, len(d.Buckets()))
+	assertEqual(t, 0, d.ViolationsData.Len())
+}
@@ -0,0 +1,73 @@
+// report.go
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/jshaughn/outlier/nelson"
+)
+
+// report evaluates the time series of a TSExpression as they are added, in any number of
+// chunks, and collects the violations to print once complete.
+type report struct {
+	ts TSExpression
+	// key=metric, value=the evaluated TS
+	tracked    map[string]*series
+	violations []nelson.Violation
+}
+
+func newReport(ts TSExpression) *report {
+	return &report{ts: ts, tracked: make(map[string]*series)}
+}
+
+// add evaluates the stream's samples, skipping samples already consumed for the TS
+func (r *report) add(s *model.SampleStream, o options) {
+	if !r.ts.matches(s.Metric) {
+		return
+	}
+
+	k := s.Metric.String()
+	ser, ok := r.tracked[k]
+	if !ok {
+		ser = newSeries(s.Metric, r.ts, o)
+		r.tracked[k] = ser
+	}
+
+	last := ser.data.LastTime()
+	values := make([]model.SamplePair, 0, len(s.Values))
+	for _, v := range s.Values {
+		if int64(v.Timestamp) > last {
+			values = append(values, v)
+		}
+	}
+
+	for _, result := range ser.data.AddSamples(toSamplePairs(values)) {
+		r.violations = append(r.violations, result.Violations...)
+	}
+}
+
+// print prints the violations in time order, followed by the state of each TS
+func (r *report) print(description string) {
+	sort.SliceStable(r.violations,
+		func(i, j int) bool {
+			return r.violations[i].Time < r.violations[j].Time
+		})
+
+	fmt.Printf("\nExpression [%s] %s: [%v] TS, [%v] violations\n", r.ts.Name, description, len(r.tracked), len(r.violations))
+	for _, v := range r.violations {
+		fmt.Printf("  %s %-6s %v value=%.2f mean=%.2f stddev=%.2f\n", time.Unix(0, v.Time*int64(time.Millisecond)).Format(TF), v.Rule, v.Metric, v.Value, v.Mean, v.StdDev)
+	}
+
+	keys := make([]string, 0, len(r.tracked))
+	for k := range r.tracked {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("  %+v\n", r.tracked[k].data)
+	}
+}
@@ -1,9 +1,9 @@
 package scrape
 
 import (
-	"log"
-	"math/rand"
+	"context"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -22,47 +22,272 @@ var (
 		},
 		[]string{"rule", "ts"},
 	)
-	responseTimes = prometheus.NewGaugeVec(
+	queryErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outlier_query_errors_total",
+			Help: "Failed query attempts, including those later retried.",
+		},
+		[]string{"expression"},
+	)
+	queryFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outlier_query_failures_total",
+			Help: "Queries that failed after exhausting retries.",
+		},
+		[]string{"expression"},
+	)
+	seriesStale = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "outlier_series_stale",
+			Help: "1 if the TS was not updated because its most recent query failed, 0 otherwise.",
+		},
+		[]string{"expression", "ts"},
+	)
+	seriesMean = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "outlier_series_mean",
+			Help: "Baseline mean of the TS, set once the baseline is established.",
+		},
+		[]string{"expression", "ts"},
+	)
+	seriesStdDev = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "outlier_series_stddev",
+			Help: "Baseline standard deviation of the TS, set once the baseline is established.",
+		},
+		[]string{"expression", "ts"},
+	)
+	seriesSamplesUntilReady = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "outlier_series_samples_until_ready",
+			Help: "Data points required to establish the baseline of the TS, 0 once established.",
+		},
+		[]string{"expression", "ts"},
+	)
+	seriesLastValue = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "response_time",
-			Help: "Response times (for testing only).",
+			Name: "outlier_series_last_value",
+			Help: "Most recently evaluated value of the TS.",
 		},
-		[]string{"variance"},
+		[]string{"expression", "ts"},
+	)
+	ruleViolating = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "outlier_rule_violating",
+			Help: "1 if the rule is currently violated (pending or firing) by the TS, 0 otherwise.",
+		},
+		[]string{"expression", "ts", "rule"},
+	)
+	seriesEvicted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outlier_series_evicted_total",
+			Help: "TS evicted, because they expired (ttl) or to stay within the maximum series (lru).",
+		},
+		[]string{"expression", "reason"},
+	)
+	queryDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "outlier_query_duration_seconds",
+			Help:    "Query attempt latency, including failed attempts.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"expression"},
+	)
+	seriesTracked = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "outlier_series_tracked",
+			Help: "Number of TS tracked for the expression.",
+		},
+		[]string{"expression"},
+	)
+	evaluationLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "outlier_evaluation_lag_seconds",
+			Help: "Time between the newest evaluated data point of the expression and its evaluation.",
+		},
+		[]string{"expression"},
 	)
 )
 
+// nelson_rule is not labeled by expression, TS tracked for several expressions share its
+// values. tracking records, by ts label, the expressions tracking the TS and the rules
+// counted for it, so the values are deleted only once no expression tracks the TS.
+var tracking = struct {
+	sync.Mutex
+	expressions map[string]map[string]bool
+	rules       map[string]map[string]bool
+}{
+	expressions: make(map[string]map[string]bool),
+	rules:       make(map[string]map[string]bool),
+}
+
+// add records key in the set m[ts], hold the tracking lock
+func add(m map[string]map[string]bool, ts, key string) {
+	if m[ts] == nil {
+		m[ts] = make(map[string]bool)
+	}
+	m[ts][key] = true
+}
+
 func (s *Scrape) Add(rule, query string, val float64) {
+	tracking.Lock()
+	add(tracking.rules, query, rule)
+	tracking.Unlock()
 	nelsonRules.WithLabelValues(rule, query).Add(val)
 }
 
-func (s *Scrape) Start() {
-	// Register the reported metrics
-	prometheus.MustRegister(nelsonRules)
-	prometheus.MustRegister(responseTimes)
+// QueryError counts a failed query attempt for the expression
+func (s *Scrape) QueryError(expression string) {
+	queryErrors.WithLabelValues(expression).Inc()
+}
 
-	// generate values every 5s, start stable and then add variance...
-	go func() {
-		rand.Seed(time.Now().Unix())
-		i := 0
-		for {
-			var stable, wild int64
-			switch {
-			case i <= 50:
-				stable = rand.Int63n(100)
-				wild = rand.Int63n(100)
-			default:
-				stable = rand.Int63n(50) + 25
-				wild = rand.Int63n(125)
-			}
-			//fmt.Printf("responseTimes: stable=%v, wild=%v\n", stable, wild)
-			responseTimes.WithLabelValues("stable").Set(float64(stable))
-			responseTimes.WithLabelValues("wild").Set(float64(wild))
-			i++
-			time.Sleep(time.Duration(5 * time.Second))
+// QueryFailed counts a query for the expression that failed after exhausting retries
+func (s *Scrape) QueryFailed(expression string) {
+	queryFailures.WithLabelValues(expression).Inc()
+}
+
+// SetStale reports whether the TS tracked for the expression is stale
+func (s *Scrape) SetStale(expression, ts string, stale bool) {
+	val := 0.0
+	if stale {
+		val = 1.0
+	}
+	seriesStale.WithLabelValues(expression, ts).Set(val)
+}
+
+// SeriesStats is the detector state of a TS
+type SeriesStats struct {
+	// Ready is true if the baseline is established, Mean and StdDev are only set if Ready
+	Ready             bool
+	Mean              float64
+	StdDev            float64
+	SamplesUntilReady int
+	// LastValue is only set if Evaluated
+	Evaluated bool
+	LastValue float64
+	// key=rule name, value=true if the rule is pending or firing
+	Violating map[string]bool
+}
+
+// SetSeries reports the detector state of the TS tracked for the expression
+func (s *Scrape) SetSeries(expression, ts string, stats SeriesStats) {
+	tracking.Lock()
+	add(tracking.expressions, ts, expression)
+	tracking.Unlock()
+
+	if stats.Ready {
+		seriesMean.WithLabelValues(expression, ts).Set(stats.Mean)
+		seriesStdDev.WithLabelValues(expression, ts).Set(stats.StdDev)
+	} else {
+		seriesMean.DeleteLabelValues(expression, ts)
+		seriesStdDev.DeleteLabelValues(expression, ts)
+	}
+	seriesSamplesUntilReady.WithLabelValues(expression, ts).Set(float64(stats.SamplesUntilReady))
+	if stats.Evaluated {
+		seriesLastValue.WithLabelValues(expression, ts).Set(stats.LastValue)
+	}
+	for rule, violating := range stats.Violating {
+		val := 0.0
+		if violating {
+			val = 1.0
 		}
-	}()
+		ruleViolating.WithLabelValues(expression, ts, rule).Set(val)
+	}
+}
+
+// DeleteSeries removes the metrics of the TS tracked for the expression, evaluated against the rules
+func (s *Scrape) DeleteSeries(expression, ts string, rules []string) {
+	seriesStale.DeleteLabelValues(expression, ts)
+	seriesMean.DeleteLabelValues(expression, ts)
+	seriesStdDev.DeleteLabelValues(expression, ts)
+	seriesSamplesUntilReady.DeleteLabelValues(expression, ts)
+	seriesLastValue.DeleteLabelValues(expression, ts)
+	for _, rule := range rules {
+		ruleViolating.DeleteLabelValues(expression, ts, rule)
+	}
+
+	tracking.Lock()
+	defer tracking.Unlock()
+	delete(tracking.expressions[ts], expression)
+	if len(tracking.expressions[ts]) > 0 {
+		return
+	}
+	for rule := range tracking.rules[ts] {
+		nelsonRules.DeleteLabelValues(rule, ts)
+	}
+	delete(tracking.expressions, ts)
+	delete(tracking.rules, ts)
+}
+
+// Evicted counts a TS tracked for the expression evicted for the reason (ttl or lru)
+func (s *Scrape) Evicted(expression, reason string) {
+	seriesEvicted.WithLabelValues(expression, reason).Inc()
+}
+
+// ObserveQuery records the latency of a query attempt for the expression
+func (s *Scrape) ObserveQuery(expression string, d time.Duration) {
+	queryDuration.WithLabelValues(expression).Observe(d.Seconds())
+}
+
+// SetTracked reports the number of TS tracked for the expression
+func (s *Scrape) SetTracked(expression string, n int) {
+	seriesTracked.WithLabelValues(expression).Set(float64(n))
+}
+
+// SetLag reports the time between the newest evaluated data point of the expression and its evaluation
+func (s *Scrape) SetLag(expression string, lag time.Duration) {
+	evaluationLag.WithLabelValues(expression).Set(lag.Seconds())
+}
+
+// DeleteExpression removes the per-expression metrics of the expression
+func (s *Scrape) DeleteExpression(expression string) {
+	seriesTracked.DeleteLabelValues(expression)
+	evaluationLag.DeleteLabelValues(expression)
+}
+
+// Register registers the reported metrics with r, Start registers them with the default
+// registry
+func Register(r prometheus.Registerer) {
+	r.MustRegister(nelsonRules)
+	r.MustRegister(queryErrors)
+	r.MustRegister(queryFailures)
+	r.MustRegister(seriesStale)
+	r.MustRegister(seriesMean)
+	r.MustRegister(seriesStdDev)
+	r.MustRegister(seriesSamplesUntilReady)
+	r.MustRegister(seriesLastValue)
+	r.MustRegister(ruleViolating)
+	r.MustRegister(seriesEvicted)
+	r.MustRegister(queryDuration)
+	r.MustRegister(seriesTracked)
+	r.MustRegister(evaluationLag)
+}
+
+// ShutdownTimeout limits how long Start waits for in-flight requests to drain
+const ShutdownTimeout = 10 * time.Second
+
+// Start serves the registered metrics until ctx is cancelled, then drains in-flight
+// requests. It returns nil on a clean shutdown, or the error preventing the server from
+// serving.
+func (s *Scrape) Start(ctx context.Context) error {
+	Register(prometheus.DefaultRegisterer)
 
 	// Expose the registered metrics via HTTP.
 	http.Handle("/metrics", promhttp.Handler())
-	log.Fatal(http.ListenAndServe(s.Endpoint, nil))
+	server := &http.Server{Addr: s.Endpoint}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
 }
@@ -0,0 +1,135 @@
+// scrape_test.go
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gauge returns the value of the named gauge with the labels gathered from r, and whether
+// it is reported
+func gauge(t *testing.T, r *prometheus.Registry, name string, labels map[string]string) (float64, bool) {
+	families, err := r.Gather()
+	assertEqual(t, nil, err)
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			matched := 0
+			for _, l := range m.GetLabel() {
+				if v, ok := labels[l.GetName()]; ok && v == l.GetValue() {
+					matched++
+				}
+			}
+			if matched == len(labels) {
+				return m.GetGauge().GetValue(), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// the detector state of a TS is reported until the TS is deleted
+func TestSetSeries(t *testing.T) {
+	r := prometheus.NewRegistry()
+	Register(r)
+	s := Scrape{}
+	expression, ts := "x", `{set="series"}`
+	labels := map[string]string{"expression": expression, "ts": ts}
+	rule1 := map[string]string{"expression": expression, "ts": ts, "rule": "Rule1"}
+	rule2 := map[string]string{"expression": expression, "ts": ts, "rule": "Rule2"}
+
+	// no baseline yet
+	s.SetSeries(expression, ts, SeriesStats{SamplesUntilReady: 4})
+	v, ok := gauge(t, r, "outlier_series_samples_until_ready", labels)
+	assertEqual(t, true, ok)
+	assertEqual(t, 4.0, v)
+	_, ok = gauge(t, r, "outlier_series_mean", labels)
+	assertEqual(t, false, ok)
+	_, ok = gauge(t, r, "outlier_series_stddev", labels)
+	assertEqual(t, false, ok)
+	_, ok = gauge(t, r, "outlier_series_last_value", labels)
+	assertEqual(t, false, ok)
+
+	s.SetSeries(expression, ts, SeriesStats{Ready: true, Mean: 10, StdDev: 2, Evaluated: true, LastValue: 18,
+		Violating: map[string]bool{"Rule1": true, "Rule2": false}})
+	v, _ = gauge(t, r, "outlier_series_samples_until_ready", labels)
+	assertEqual(t, 0.0, v)
+	v, _ = gauge(t, r, "outlier_series_mean", labels)
+	assertEqual(t, 10.0, v)
+	v, _ = gauge(t, r, "outlier_series_stddev", labels)
+	assertEqual(t, 2.0, v)
+	v, _ = gauge(t, r, "outlier_series_last_value", labels)
+	assertEqual(t, 18.0, v)
+	v, _ = gauge(t, r, "outlier_rule_violating", rule1)
+	assertEqual(t, 1.0, v)
+	v, ok = gauge(t, r, "outlier_rule_violating", rule2)
+	assertEqual(t, true, ok)
+	assertEqual(t, 0.0, v)
+
+	s.SetStale(expression, ts, true)
+	v, _ = gauge(t, r, "outlier_series_stale", labels)
+	assertEqual(t, 1.0, v)
+
+	// a cleared baseline is no longer reported
+	s.SetSeries(expression, ts, SeriesStats{SamplesUntilReady: 10})
+	_, ok = gauge(t, r, "outlier_series_mean", labels)
+	assertEqual(t, false, ok)
+	_, ok = gauge(t, r, "outlier_series_stddev", labels)
+	assertEqual(t, false, ok)
+
+	s.DeleteSeries(expression, ts, []string{"Rule1", "Rule2"})
+	for _, name := range []string{"outlier_series_stale", "outlier_series_mean", "outlier_series_stddev",
+		"outlier_series_samples_until_ready", "outlier_series_last_value"} {
+		_, ok = gauge(t, r, name, labels)
+		assertEqual(t, false, ok)
+	}
+	_, ok = gauge(t, r, "outlier_rule_violating", rule1)
+	assertEqual(t, false, ok)
+	_, ok = gauge(t, r, "outlier_rule_violating", rule2)
+	assertEqual(t, false, ok)
+}
+
+// cancelling ctx shuts the server down cleanly. Start registers the metrics with the
+// default registry, so it is started once per test binary.
+func TestStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := Scrape{Endpoint: ":0"}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Start(ctx)
+	}()
+
+	// still serving
+	select {
+	case err := <-done:
+		t.Fatalf("Expected Start to serve until cancelled, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		assertEqual(t, nil, err)
+	case <-time.After(ShutdownTimeout):
+		t.Fatal("Expected Start to return once cancelled")
+	}
+}
+
+func assertEqual(t *testing.T, e interface{}, v interface{}) {
+	if reflect.TypeOf(e) != reflect.TypeOf(v) {
+		debug.PrintStack()
+		t.Fatal(fmt.Sprintf("Expected |%v|, Got |%v|", reflect.TypeOf(e), reflect.TypeOf(v)))
+	}
+	if e != v {
+		debug.PrintStack()
+		t.Fatal(fmt.Sprintf("Expected |%v|, Got |%v|", e, v))
+	}
+}
@@ -0,0 +1,69 @@
+// simulate.go
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jshaughn/outlier/scrape"
+	"github.com/jshaughn/outlier/simulate"
+)
+
+// simulateCmd prints o.simPoints simulated data points per waveform as csv or, if not
+// set, serves the simulated gauge on the scrape endpoint until SIGINT or SIGTERM.
+func simulateCmd(o options) error {
+	if o.simPoints > 0 {
+		return printSimulated(o)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-term
+		fmt.Printf("Received %v, shutting down\n", sig)
+		cancel()
+	}()
+
+	sim := simulate.New(o.simMetric, o.simInterval, o.simulate, simulate.Params{})
+	go sim.Run(ctx)
+
+	fmt.Printf("Simulating %s %v every %v on [%s]\n", o.simMetric, o.simulate, o.simInterval, o.endpoint)
+	ep := scrape.Scrape{Endpoint: o.endpoint}
+	return ep.Start(ctx)
+}
+
+// printSimulated writes the simulated data points, one interval apart and ending now, to
+// stdout in the csv input format.
+func printSimulated(o options) error {
+	values := make([][]float64, len(o.simulate))
+	for i, w := range o.simulate {
+		values[i] = w.Generate(o.simPoints, simulate.Params{}, o.simSeed)
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	if err := w.Write([]string{"timestamp", "metric", "waveform", "value"}); err != nil {
+		return err
+	}
+	end := time.Now()
+	for i := 0; i < o.simPoints; i++ {
+		t := end.Add(-time.Duration(o.simPoints-1-i) * o.simInterval)
+		timestamp := strconv.FormatFloat(float64(t.UnixNano()/int64(time.Millisecond))/1000, 'f', -1, 64)
+		for j, wf := range o.simulate {
+			value := strconv.FormatFloat(values[j][i], 'f', -1, 64)
+			if err := w.Write([]string{timestamp, o.simMetric, string(wf), value}); err != nil {
+				return err
+			}
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
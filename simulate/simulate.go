@@ -0,0 +1,186 @@
+// simulate.go
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Waveform shapes simulated values. After a warmup of stable values, long enough to
+// establish the baseline, each Waveform is designed to trip specific Nelson rules.
+type Waveform string
+
+const (
+	// Stable values are normally distributed around the mean, no rules should trip
+	Stable Waveform = "stable"
+	// Noisy values have three times the standard deviation, tripping Rule1 and Rule5
+	Noisy Waveform = "noisy"
+	// Drift values increase steadily, tripping Rule3
+	Drift Waveform = "drift"
+	// Step values shift two standard deviations above the mean, tripping Rule2, Rule5 and Rule6
+	Step Waveform = "step"
+	// Spike values are stable, except for a spike every Period values, tripping Rule1
+	Spike Waveform = "spike"
+	// Oscillation values alternate above and below the mean, tripping Rule4
+	Oscillation Waveform = "oscillation"
+	// Seasonal values follow a sine wave with a cycle of Period values, without warmup. They
+	// trip rules unless evaluated with a matching seasonality.
+	Seasonal Waveform = "seasonal"
+)
+
+// Waveforms are all of the Waveforms
+var Waveforms = []Waveform{Stable, Noisy, Drift, Step, Spike, Oscillation, Seasonal}
+
+// ParseWaveforms returns the Waveforms for a comma-separated list of names, or "all".
+func ParseWaveforms(names string) ([]Waveform, error) {
+	if names == "all" {
+		return Waveforms, nil
+	}
+
+	var result []Waveform
+	for _, name := range strings.Split(names, ",") {
+		found := false
+		for _, w := range Waveforms {
+			if string(w) == strings.TrimSpace(name) {
+				result = append(result, w)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Unknown waveform [%s], valid waveforms: %v", name, Waveforms)
+		}
+	}
+	return result, nil
+}
+
+// Params shape the Waveforms. Unset fields are defaulted.
+type Params struct {
+	// Mean of the stable values, defaults to 50
+	Mean float64
+	// StdDev of the stable values, defaults to 5
+	StdDev float64
+	// Warmup is the number of stable values before the anomaly, defaults to 50
+	Warmup int
+	// Period of the spike and seasonal Waveforms, in values, defaults to 24
+	Period int
+}
+
+func (p Params) withDefaults() Params {
+	if p.Mean == 0 {
+		p.Mean = 50
+	}
+	if p.StdDev <= 0 {
+		p.StdDev = 5
+	}
+	if p.Warmup <= 0 {
+		p.Warmup = 50
+	}
+	if p.Period <= 0 {
+		p.Period = 24
+	}
+	return p
+}
+
+// Value returns the i'th value (from 0) of the Waveform
+func (w Waveform) Value(i int, p Params, rnd *rand.Rand) float64 {
+	p = p.withDefaults()
+	noise := rnd.NormFloat64() * p.StdDev
+	if w == Seasonal {
+		return p.Mean + 3*p.StdDev*math.Sin(2*math.Pi*float64(i)/float64(p.Period)) + noise/2
+	}
+	if i < p.Warmup {
+		return p.Mean + noise
+	}
+
+	n := i - p.Warmup
+	switch w {
+	case Noisy:
+		return p.Mean + 3*noise
+	case Drift:
+		return p.Mean + float64(n)*p.StdDev/2 + noise/10
+	case Step:
+		return p.Mean + 2*p.StdDev + noise/2
+	case Spike:
+		if n%p.Period == 0 {
+			return p.Mean + 6*p.StdDev
+		}
+		return p.Mean + noise
+	case Oscillation:
+		v := p.StdDev + math.Abs(noise)/2
+		if n%2 == 1 {
+			v = -v
+		}
+		return p.Mean + v
+	default:
+		return p.Mean + noise
+	}
+}
+
+// Generate returns the first n values of the Waveform
+func (w Waveform) Generate(n int, p Params, seed int64) []float64 {
+	rnd := rand.New(rand.NewSource(seed))
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = w.Value(i, p, rnd)
+	}
+	return values
+}
+
+// Simulator exports a gauge with a TS for each Waveform, labeled waveform=<name>. It is
+// meant for demos and end-to-end testing, not production.
+type Simulator struct {
+	// Metric is the gauge name
+	Metric    string
+	Interval  time.Duration
+	Waveforms []Waveform
+	Params    Params
+	gauge     *prometheus.GaugeVec
+}
+
+// New returns a Simulator updating the metric every interval.
+func New(metric string, interval time.Duration, waveforms []Waveform, p Params) *Simulator {
+	return &Simulator{
+		Metric:    metric,
+		Interval:  interval,
+		Waveforms: waveforms,
+		Params:    p,
+		gauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: metric,
+				Help: "Simulated values (for testing only).",
+			},
+			[]string{"waveform"},
+		),
+	}
+}
+
+// Run() is expected to execute as a goroutine. It registers the gauge with the default
+// registry and updates it every Interval, until ctx is cancelled.
+func (s *Simulator) Run(ctx context.Context) {
+	prometheus.MustRegister(s.gauge)
+	defer prometheus.Unregister(s.gauge)
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := 0; ; i++ {
+		s.update(i, rnd)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.Interval):
+		}
+	}
+}
+
+// update sets the gauge to the i'th value of each Waveform
+func (s *Simulator) update(i int, rnd *rand.Rand) {
+	for _, w := range s.Waveforms {
+		s.gauge.WithLabelValues(string(w)).Set(w.Value(i, s.Params, rnd))
+	}
+}
@@ -0,0 +1,140 @@
+// simulate_test.go
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jshaughn/outlier/nelson"
+)
+
+// evaluate returns the violation counts for the Waveform, evaluated against all rules
+// with a baseline established during the warmup. With seasonality it is evaluated for 60
+// days, so each bucket establishes its baseline.
+func evaluate(w Waveform, seasonality nelson.Seasonality) map[string]int {
+	d := nelson.NewDataWithOptions(w, nelson.Options{SampleSize: 50, Seasonality: seasonality})
+	n := 90
+	if seasonality != nelson.NoSeasonality {
+		n = 60 * 24
+	}
+	values := w.Generate(n, Params{}, 1)
+	samples := make([]nelson.Sample, len(values))
+	for i, v := range values {
+		// one value an hour, so the seasonal period is a day
+		samples[i] = nelson.Point{T: int64(i) * time.Hour.Nanoseconds() / 1e6, V: v}
+	}
+	d.AddSamples(samples)
+	return d.Violations
+}
+
+func TestWaveforms(t *testing.T) {
+	assertEqual(t, 0, len(evaluate(Stable, nelson.NoSeasonality)))
+
+	violations := evaluate(Noisy, nelson.NoSeasonality)
+	assertEqual(t, true, violations[nelson.Rule1.Name] > 0)
+	assertEqual(t, true, violations[nelson.Rule5.Name] > 0)
+
+	violations = evaluate(Drift, nelson.NoSeasonality)
+	assertEqual(t, true, violations[nelson.Rule3.Name] > 0)
+
+	violations = evaluate(Step, nelson.NoSeasonality)
+	assertEqual(t, true, violations[nelson.Rule2.Name] > 0)
+	assertEqual(t, true, violations[nelson.Rule5.Name] > 0)
+	assertEqual(t, true, violations[nelson.Rule6.Name] > 0)
+
+	violations = evaluate(Spike, nelson.NoSeasonality)
+	assertEqual(t, 1, len(violations))
+	assertEqual(t, 2, violations[nelson.Rule1.Name])
+
+	violations = evaluate(Oscillation, nelson.NoSeasonality)
+	assertEqual(t, true, violations[nelson.Rule4.Name] > 0)
+
+	violations = evaluate(Seasonal, nelson.NoSeasonality)
+	assertEqual(t, true, len(violations) > 0)
+}
+
+// the seasonal Waveform trips rules against a single baseline, but not against a baseline
+// per hour of the day
+func TestSeasonalWaveform(t *testing.T) {
+	violations := evaluate(Seasonal, nelson.NoSeasonality)
+	assertEqual(t, true, violations[nelson.Rule2.Name] > 0)
+	assertEqual(t, true, violations[nelson.Rule3.Name] > 0)
+
+	violations = evaluate(Seasonal, nelson.HourOfDay)
+	assertEqual(t, 0, violations[nelson.Rule2.Name])
+	assertEqual(t, 0, violations[nelson.Rule3.Name])
+	assertEqual(t, 0, violations[nelson.Rule6.Name])
+}
+
+func TestParseWaveforms(t *testing.T) {
+	waveforms, err := ParseWaveforms("all")
+	assertEqual(t, nil, err)
+	assertEqual(t, len(Waveforms), len(waveforms))
+
+	waveforms, err = ParseWaveforms("stable, step")
+	assertEqual(t, nil, err)
+	assertEqual(t, 2, len(waveforms))
+	assertEqual(t, Step, waveforms[1])
+
+	_, err = ParseWaveforms("stable,square")
+	assertEqual(t, "Unknown waveform [square], valid waveforms: [stable noisy drift step spike oscillation seasonal]", fmt.Sprint(err))
+}
+
+// gathered returns the values of the simulated gauge, by waveform
+func gathered(t *testing.T, g prometheus.Gatherer) map[string]float64 {
+	families, err := g.Gather()
+	assertEqual(t, nil, err)
+	result := make(map[string]float64)
+	for _, mf := range families {
+		if mf.GetName() != "simulated" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			result[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+		}
+	}
+	return result
+}
+
+func TestSimulator(t *testing.T) {
+	s := New("simulated", time.Hour, []Waveform{Stable, Step}, Params{})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(s.gauge)
+	s.update(0, rand.New(rand.NewSource(1)))
+
+	values := gathered(t, reg)
+	assertEqual(t, 2, len(values))
+	rnd := rand.New(rand.NewSource(1))
+	assertEqual(t, Stable.Value(0, Params{}, rnd), values[string(Stable)])
+	assertEqual(t, Step.Value(0, Params{}, rnd), values[string(Step)])
+}
+
+// Run registers the gauge until cancelled
+func TestSimulatorRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := New("simulated", time.Hour, []Waveform{Stable, Step}, Params{})
+	s.Run(ctx)
+	assertEqual(t, 0, len(gathered(t, prometheus.DefaultGatherer)))
+
+	// the gauge was unregistered, so it can be registered again
+	s.Run(ctx)
+}
+
+func assertEqual(t *testing.T, e interface{}, v interface{}) {
+	if reflect.TypeOf(e) != reflect.TypeOf(v) {
+		debug.PrintStack()
+		t.Fatal(fmt.Sprintf("Expected |%v|, Got |%v|", reflect.TypeOf(e), reflect.TypeOf(v)))
+	}
+	if e != v {
+		debug.PrintStack()
+		t.Fatal(fmt.Sprintf("Expected |%v|, Got |%v|", e, v))
+	}
+}
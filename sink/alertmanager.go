@@ -0,0 +1,146 @@
+// alertmanager.go
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultResolveTimeout is the lifetime of an Alertmanager alert, by default
+const DefaultResolveTimeout = 5 * time.Minute
+
+// AlertName is the alertname label of Alertmanager alerts
+const AlertName = "OutlierRuleViolation"
+
+// Alertmanager pushes firing and resolved Events as alerts to the Alertmanager v2 API.
+// Firing alerts are re-sent by Run, before the Alertmanager considers them resolved.
+type Alertmanager struct {
+	sync.Mutex
+	// URL of the Alertmanager, e.g. http://alertmanager:9093
+	URL string
+	// ResolveTimeout is the lifetime of an alert, it is resolved if not sent again
+	ResolveTimeout time.Duration
+	client         *http.Client
+	// key=alertKey, value=firing alert
+	firing map[string]alert
+}
+
+// alert is a postableAlert of the Alertmanager v2 API
+type alert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// NewAlertmanager returns an Alertmanager sink posting to url with client
+func NewAlertmanager(url string, resolveTimeout time.Duration, client *http.Client) *Alertmanager {
+	return &Alertmanager{
+		URL:            url,
+		ResolveTimeout: resolveTimeout,
+		client:         client,
+		firing:         make(map[string]alert),
+	}
+}
+
+// Send posts firing and resolved Events, other Events are ignored
+func (a *Alertmanager) Send(e Event) error {
+	var al alert
+	k := alertKey(e)
+	switch e.State {
+	case "firing":
+		al = a.alert(e)
+		a.Lock()
+		a.firing[k] = al
+		a.Unlock()
+		al.EndsAt = time.Now().Add(a.ResolveTimeout)
+	case "resolved":
+		al = a.alert(e)
+		a.Lock()
+		if firing, ok := a.firing[k]; ok {
+			al.StartsAt = firing.StartsAt
+		}
+		delete(a.firing, k)
+		a.Unlock()
+		al.EndsAt = time.Unix(0, e.Time*int64(time.Millisecond)).UTC()
+	default:
+		return nil
+	}
+	return a.post([]alert{al})
+}
+
+// Run re-sends the firing alerts every half ResolveTimeout
+func (a *Alertmanager) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(a.ResolveTimeout / 2):
+		}
+		if err := a.refresh(); err != nil {
+			fmt.Printf("Failed to refresh alerts for sink [%v]: %v\n", a, err)
+		}
+	}
+}
+
+// refresh re-sends the firing alerts, extending their lifetime
+func (a *Alertmanager) refresh() error {
+	a.Lock()
+	alerts := make([]alert, 0, len(a.firing))
+	endsAt := time.Now().Add(a.ResolveTimeout)
+	for _, al := range a.firing {
+		al.EndsAt = endsAt
+		alerts = append(alerts, al)
+	}
+	a.Unlock()
+
+	if len(alerts) == 0 {
+		return nil
+	}
+	return a.post(alerts)
+}
+
+func (a *Alertmanager) post(alerts []alert) error {
+	return postJSON(a.client, strings.TrimSuffix(a.URL, "/")+"/api/v2/alerts", nil, alerts)
+}
+
+// alertKey uniquely identifies the alert for the Event's TS and Rule
+func alertKey(e Event) string {
+	return fmt.Sprintf("%s/%v/%s", e.Expression, e.Labels, e.Rule)
+}
+
+// alert returns the alert for e. Alerts for the same TS and Rule have the same labels,
+// and so are grouped and deduplicated by the Alertmanager.
+func (a *Alertmanager) alert(e Event) alert {
+	v := e.Violation
+	labels := make(map[string]string, len(e.Labels)+3)
+	for k, lv := range e.Labels {
+		if k == "__name__" {
+			k = "metric"
+		}
+		labels[k] = lv
+	}
+	labels["alertname"] = AlertName
+	labels["expression"] = e.Expression
+	labels["rule"] = e.Rule
+
+	return alert{
+		Labels: labels,
+		Annotations: map[string]string{
+			"description": v.Description,
+			"value":       fmt.Sprintf("%g", v.Value),
+			"mean":        fmt.Sprintf("%g", v.Mean),
+			"stddev":      fmt.Sprintf("%g", v.StdDev),
+		},
+		StartsAt: time.Unix(0, e.Time*int64(time.Millisecond)).UTC(),
+	}
+}
+
+func (a *Alertmanager) String() string {
+	return "alertmanager(" + a.URL + ")"
+}
@@ -0,0 +1,39 @@
+// file.go
+package sink
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// File appends each Event, as a line of JSON, to a file. The file is opened for each
+// Event, so it can be rotated externally.
+type File struct {
+	sync.Mutex
+	Path string
+}
+
+func (f *File) Send(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(append(b, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (f *File) String() string {
+	return "file(" + f.Path + ")"
+}
@@ -0,0 +1,219 @@
+// sink.go
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/jshaughn/outlier/nelson"
+)
+
+// DefaultTimeout limits each HTTP request made by a Sink, by default
+const DefaultTimeout = 5 * time.Second
+
+// Event is a change in the AlertState of a Rule, for a TS of a watched expression.
+type Event struct {
+	Expression string            `json:"expression"`
+	Labels     map[string]string `json:"labels"`
+	Rule       string            `json:"rule"`
+	// From and State are the previous and new AlertState
+	From  string `json:"from"`
+	State string `json:"state"`
+	Time  int64  `json:"time"` // unix time in ms
+	// Violation is the most recent Violation of the Rule
+	Violation nelson.Violation `json:"violation"`
+}
+
+// Sink delivers Events. Send may be called concurrently.
+type Sink interface {
+	Send(e Event) error
+	String() string
+}
+
+// Runner is implemented by Sinks performing background work.
+type Runner interface {
+	// Run performs the background work, it returns when ctx is cancelled
+	Run(ctx context.Context)
+}
+
+// Sinks delivers Events to every Sink.
+type Sinks []Sink
+
+// Start runs the background work of every Sink implementing Runner, until ctx is cancelled.
+func (s Sinks) Start(ctx context.Context) {
+	for _, sink := range s {
+		if f, ok := sink.(Filtered); ok {
+			sink = f.Sink
+		}
+		if r, ok := sink.(Runner); ok {
+			go r.Run(ctx)
+		}
+	}
+}
+
+// Send delivers e to every Sink. A failing Sink is reported and does not prevent
+// delivery to the others.
+func (s Sinks) Send(e Event) {
+	for _, sink := range s {
+		if err := sink.Send(e); err != nil {
+			fmt.Printf("Failed to send event to sink [%v]: %v\n", sink, err)
+		}
+	}
+}
+
+// Config configures a Sink. It is defined in the config file.
+type Config struct {
+	// Type is one of: alertmanager, webhook, file, log
+	Type string `yaml:"type"`
+	// URL is the Alertmanager or webhook URL
+	URL string `yaml:"url"`
+	// Headers are added to webhook requests, e.g. Authorization
+	Headers map[string]string `yaml:"headers"`
+	// Timeout limits each HTTP request, defaults to DefaultTimeout
+	Timeout time.Duration `yaml:"timeout"`
+	// ResolveTimeout is the lifetime of an Alertmanager alert, defaults to DefaultResolveTimeout
+	ResolveTimeout time.Duration `yaml:"resolveTimeout"`
+	// Path is the JSONL file
+	Path   string `yaml:"path"`
+	Filter `yaml:",inline"`
+}
+
+var types = []string{"alertmanager", "webhook", "file", "log"}
+
+// New returns the Sink configured by c, delivering only the Events matching c.Filter.
+func New(c Config) (Sink, error) {
+	if c.Timeout < 0 {
+		return nil, errors.New("Timeout must be >= 0")
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultTimeout
+	}
+	client := &http.Client{Timeout: c.Timeout}
+
+	var s Sink
+	switch c.Type {
+	case "alertmanager":
+		if c.URL == "" {
+			return nil, errors.New("URL must be set")
+		}
+		if c.ResolveTimeout < 0 {
+			return nil, errors.New("ResolveTimeout must be >= 0")
+		}
+		if c.ResolveTimeout == 0 {
+			c.ResolveTimeout = DefaultResolveTimeout
+		}
+		s = NewAlertmanager(c.URL, c.ResolveTimeout, client)
+	case "webhook":
+		if c.URL == "" {
+			return nil, errors.New("URL must be set")
+		}
+		s = &Webhook{URL: c.URL, Headers: c.Headers, client: client}
+	case "file":
+		if c.Path == "" {
+			return nil, errors.New("Path must be set")
+		}
+		s = &File{Path: c.Path}
+	case "log":
+		s = Log{}
+	default:
+		return nil, fmt.Errorf("Unknown sink type [%s], valid types: %v", c.Type, types)
+	}
+
+	if len(c.Filter.Rules) == 0 && len(c.Filter.Labels) == 0 {
+		return s, nil
+	}
+	return Filtered{Sink: s, Filter: c.Filter}, nil
+}
+
+// Filter selects the Events delivered to a Sink. The zero value selects every Event.
+type Filter struct {
+	// Rules, if set, are the names of the Rules delivered
+	Rules []string `yaml:"rules"`
+	// Labels, if set, must all match the TS labels
+	Labels map[string]string `yaml:"labels"`
+}
+
+// Matches returns true if e is selected by the Filter
+func (f Filter) Matches(e Event) bool {
+	if len(f.Rules) > 0 {
+		found := false
+		for _, r := range f.Rules {
+			if r == e.Rule {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for k, v := range f.Labels {
+		if e.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Filtered delivers the Events matching Filter to Sink.
+type Filtered struct {
+	Sink
+	Filter Filter
+}
+
+func (f Filtered) Send(e Event) error {
+	if !f.Filter.Matches(e) {
+		return nil
+	}
+	return f.Sink.Send(e)
+}
+
+func (f Filtered) String() string {
+	return fmt.Sprintf("%v(rules=%v, labels=%v)", f.Sink, f.Filter.Rules, f.Filter.Labels)
+}
+
+// postJSON posts body, marshalled to JSON, to url. A non-2xx response is an error.
+func postJSON(client *http.Client, url string, headers map[string]string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("POST %s returned [%s]: %s", url, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Log prints Events to stdout.
+type Log struct{}
+
+func (l Log) Send(e Event) error {
+	v := e.Violation
+	fmt.Printf("Alert [%s->%s] %s %s [%s] value=%.2f mean=%.2f stddev=%.2f window=%v\n", e.From, e.State, e.Rule, v.Metric, e.Expression, v.Value, v.Mean, v.StdDev, v.Window)
+	return nil
+}
+
+func (l Log) String() string {
+	return "log"
+}
@@ -0,0 +1,206 @@
+// sink_test.go
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/jshaughn/outlier/nelson"
+)
+
+var testEvent = Event{
+	Expression: "response_time_stable",
+	Labels:     map[string]string{"__name__": "response_time", "variance": "stable"},
+	Rule:       "Rule1",
+	From:       "inactive",
+	State:      "firing",
+	Time:       1546300800000,
+	Violation: nelson.Violation{
+		Rule:        "Rule1",
+		Description: "One point is more than 3 standard deviations from the mean.",
+		Metric:      `response_time{variance="stable"}`,
+		Time:        1546300800000,
+		Value:       18.0,
+		Mean:        10.0,
+		StdDev:      2.5,
+		Window:      []nelson.Point{{T: 1546300800000, V: 18.0}},
+	},
+}
+
+// recorder is an HTTP stand-in recording the requests it receives
+type recorder struct {
+	status int
+	paths  []string
+	bodies [][]byte
+	auth   []string
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.paths = append(r.paths, req.URL.Path)
+	r.bodies = append(r.bodies, body)
+	r.auth = append(r.auth, req.Header.Get("Authorization"))
+	w.WriteHeader(r.status)
+}
+
+func TestAlertmanager(t *testing.T) {
+	rec := &recorder{status: http.StatusOK}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	s, err := New(Config{Type: "alertmanager", URL: server.URL + "/"})
+	assertEqual(t, nil, err)
+	assertEqual(t, nil, s.Send(testEvent))
+	assertEqual(t, 1, len(rec.paths))
+	assertEqual(t, "/api/v2/alerts", rec.paths[0])
+
+	var alerts []alert
+	assertEqual(t, nil, json.Unmarshal(rec.bodies[0], &alerts))
+	assertEqual(t, 1, len(alerts))
+	a := alerts[0]
+	assertEqual(t, AlertName, a.Labels["alertname"])
+	assertEqual(t, "Rule1", a.Labels["rule"])
+	assertEqual(t, "response_time_stable", a.Labels["expression"])
+	assertEqual(t, "response_time", a.Labels["metric"])
+	assertEqual(t, "stable", a.Labels["variance"])
+	assertEqual(t, "", a.Labels["__name__"])
+	assertEqual(t, "18", a.Annotations["value"])
+	assertEqual(t, "2019-01-01T00:00:00Z", a.StartsAt.Format(time.RFC3339))
+	assertEqual(t, true, a.EndsAt.After(time.Now()))
+
+	// firing alerts are refreshed
+	am := s.(*Alertmanager)
+	assertEqual(t, nil, am.refresh())
+	assertEqual(t, 2, len(rec.bodies))
+	assertEqual(t, nil, json.Unmarshal(rec.bodies[1], &alerts))
+	assertEqual(t, 1, len(alerts))
+	assertEqual(t, "2019-01-01T00:00:00Z", alerts[0].StartsAt.Format(time.RFC3339))
+
+	// pending is not sent
+	pending := testEvent
+	pending.State = "pending"
+	assertEqual(t, nil, s.Send(pending))
+	assertEqual(t, 2, len(rec.bodies))
+
+	resolved := testEvent
+	resolved.From = "firing"
+	resolved.State = "resolved"
+	resolved.Time = 1546300860000
+	assertEqual(t, nil, s.Send(resolved))
+	assertEqual(t, 3, len(rec.bodies))
+	assertEqual(t, nil, json.Unmarshal(rec.bodies[2], &alerts))
+	assertEqual(t, "2019-01-01T00:00:00Z", alerts[0].StartsAt.Format(time.RFC3339))
+	assertEqual(t, "2019-01-01T00:01:00Z", alerts[0].EndsAt.Format(time.RFC3339))
+
+	// nothing left to refresh
+	assertEqual(t, nil, am.refresh())
+	assertEqual(t, 3, len(rec.bodies))
+
+	rec.status = http.StatusBadRequest
+	assertEqual(t, true, s.Send(testEvent) != nil)
+}
+
+func TestWebhook(t *testing.T) {
+	rec := &recorder{status: http.StatusAccepted}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	s, err := New(Config{Type: "webhook", URL: server.URL + "/hook", Headers: map[string]string{"Authorization": "Bearer secret"}})
+	assertEqual(t, nil, err)
+	assertEqual(t, nil, s.Send(testEvent))
+	assertEqual(t, "/hook", rec.paths[0])
+	assertEqual(t, "Bearer secret", rec.auth[0])
+
+	var e Event
+	assertEqual(t, nil, json.Unmarshal(rec.bodies[0], &e))
+	assertEqual(t, "response_time_stable", e.Expression)
+	assertEqual(t, "Rule1", e.Rule)
+	assertEqual(t, "firing", e.State)
+	assertEqual(t, "Rule1", e.Violation.Rule)
+	assertEqual(t, int64(1546300800000), e.Violation.Time)
+	assertEqual(t, 1, len(e.Violation.Window))
+
+	server.Close()
+	assertEqual(t, true, s.Send(testEvent) != nil)
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	assertEqual(t, nil, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "violations.jsonl")
+
+	s, err := New(Config{Type: "file", Path: path})
+	assertEqual(t, nil, err)
+	assertEqual(t, nil, s.Send(testEvent))
+	assertEqual(t, nil, s.Send(testEvent))
+
+	f, err := os.Open(path)
+	assertEqual(t, nil, err)
+	defer f.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); lines++ {
+		var e Event
+		assertEqual(t, nil, json.Unmarshal(scanner.Bytes(), &e))
+		assertEqual(t, "Rule1", e.Rule)
+	}
+	assertEqual(t, 2, lines)
+}
+
+func TestFilter(t *testing.T) {
+	rec := &recorder{status: http.StatusOK}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	s, err := New(Config{Type: "webhook", URL: server.URL, Filter: Filter{Rules: []string{"Rule2", "Rule1"}}})
+	assertEqual(t, nil, err)
+	assertEqual(t, nil, s.Send(testEvent))
+	assertEqual(t, 1, len(rec.bodies))
+
+	s, err = New(Config{Type: "webhook", URL: server.URL, Filter: Filter{Rules: []string{"Rule2"}}})
+	assertEqual(t, nil, err)
+	assertEqual(t, nil, s.Send(testEvent))
+	assertEqual(t, 1, len(rec.bodies))
+
+	s, err = New(Config{Type: "webhook", URL: server.URL, Filter: Filter{Labels: map[string]string{"variance": "stable"}}})
+	assertEqual(t, nil, err)
+	assertEqual(t, nil, s.Send(testEvent))
+	assertEqual(t, 2, len(rec.bodies))
+
+	s, err = New(Config{Type: "webhook", URL: server.URL, Filter: Filter{Labels: map[string]string{"variance": "wild"}}})
+	assertEqual(t, nil, err)
+	assertEqual(t, nil, s.Send(testEvent))
+	assertEqual(t, 2, len(rec.bodies))
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{Type: "pager"})
+	assertEqual(t, "Unknown sink type [pager], valid types: [alertmanager webhook file log]", fmt.Sprint(err))
+	_, err = New(Config{Type: "alertmanager"})
+	assertEqual(t, "URL must be set", fmt.Sprint(err))
+	_, err = New(Config{Type: "file"})
+	assertEqual(t, "Path must be set", fmt.Sprint(err))
+	_, err = New(Config{Type: "webhook", URL: "http://localhost", Timeout: -time.Second})
+	assertEqual(t, "Timeout must be >= 0", fmt.Sprint(err))
+}
+
+func assertEqual(t *testing.T, e interface{}, v interface{}) {
+	if reflect.TypeOf(e) != reflect.TypeOf(v) {
+		debug.PrintStack()
+		t.Fatal(fmt.Sprintf("Expected |%v|, Got |%v|", reflect.TypeOf(e), reflect.TypeOf(v)))
+	}
+	if e != v {
+		debug.PrintStack()
+		t.Fatal(fmt.Sprintf("Expected |%v|, Got |%v|", e, v))
+	}
+}
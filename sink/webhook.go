@@ -0,0 +1,22 @@
+// webhook.go
+package sink
+
+import (
+	"net/http"
+)
+
+// Webhook posts each Event, as JSON, to a URL.
+type Webhook struct {
+	URL string
+	// Headers are added to each request
+	Headers map[string]string
+	client  *http.Client
+}
+
+func (w *Webhook) Send(e Event) error {
+	return postJSON(w.client, w.URL, w.Headers, e)
+}
+
+func (w *Webhook) String() string {
+	return "webhook(" + w.URL + ")"
+}
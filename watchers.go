@@ -0,0 +1,158 @@
+// watchers.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api/prometheus/v1"
+
+	"github.com/jshaughn/outlier/scrape"
+)
+
+// watcher is a running TSExpression.process goroutine
+type watcher struct {
+	ts     TSExpression
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// watchers manages the running watchers, one per TSExpression
+type watchers struct {
+	sync.Mutex
+	// parent of the watcher contexts
+	ctx     context.Context
+	o       options
+	api     v1.API
+	ep      scrape.Scrape
+	running map[string]*watcher
+}
+
+func newWatchers(ctx context.Context, o options, api v1.API, ep scrape.Scrape) *watchers {
+	return &watchers{
+		ctx:     ctx,
+		o:       o,
+		api:     api,
+		ep:      ep,
+		running: make(map[string]*watcher),
+	}
+}
+
+// apply starts, stops and restarts watchers such that exactly the given TSExpressions are
+// watched. Unchanged watchers keep running. Tracked TS keep their state unless their
+// TSExpression is removed, or changed in a way that invalidates the state.
+func (w *watchers) apply(tsExpressions []TSExpression) {
+	w.Lock()
+	defer w.Unlock()
+
+	wanted := make(map[string]TSExpression, len(tsExpressions))
+	for _, ts := range tsExpressions {
+		wanted[ts.Name] = ts
+	}
+
+	for name, running := range w.running {
+		ts, ok := wanted[name]
+		switch {
+		case !ok:
+			fmt.Printf("Stop watching expression [%s]\n", name)
+			running.stop()
+			delete(w.running, name)
+			untrack(name, w.ep)
+		case !ts.equals(running.ts):
+			fmt.Printf("Restart watching expression [%s]\n", name)
+			running.stop()
+			delete(w.running, name)
+			if !ts.stateCompatible(running.ts) {
+				untrack(name, w.ep)
+			}
+		}
+	}
+
+	for _, ts := range tsExpressions {
+		if _, ok := w.running[ts.Name]; ok {
+			continue
+		}
+		fmt.Printf("Start watching expression [%s]\n", ts.Name)
+		ctx, cancel := context.WithCancel(w.ctx)
+		running := &watcher{ts: ts, cancel: cancel, done: make(chan struct{})}
+		w.running[ts.Name] = running
+		go func() {
+			defer close(running.done)
+			running.ts.process(ctx, w.o, w.api, w.ep)
+		}()
+	}
+}
+
+// stopAll stops all running watchers, waiting for in-flight queries to be cancelled
+func (w *watchers) stopAll() {
+	w.Lock()
+	defer w.Unlock()
+
+	for name, running := range w.running {
+		running.stop()
+		delete(w.running, name)
+	}
+}
+
+// stop cancels the watcher and waits for it to exit
+func (w *watcher) stop() {
+	w.cancel()
+	<-w.done
+}
+
+// equals returns true if the TSExpressions are configured identically
+func (ts TSExpression) equals(other TSExpression) bool {
+	ts.rules, other.rules = nil, nil
+	return reflect.DeepEqual(ts, other)
+}
+
+// stateCompatible returns true if TS tracked for other can continue to be tracked for ts
+func (ts TSExpression) stateCompatible(other TSExpression) bool {
+	return ts.Expr == other.Expr && ts.SampleSize == other.SampleSize && ts.Rules == other.Rules &&
+		ts.alertFor() == other.alertFor() && ts.ResolveAfter == other.ResolveAfter
+}
+
+// untrack discards the TS tracked for the named TSExpression, and their metrics
+func untrack(expression string, ep scrape.Scrape) {
+	nelsonMap.Range(
+		func(k interface{}, v interface{}) bool {
+			if ser := v.(*series); ser.expression == expression {
+				send(forget(k, ser, ep))
+			}
+			return true
+		})
+	ep.DeleteExpression(expression)
+}
+
+// watchConfig() is expected to execute as a goroutine. It signals reload when the
+// modification time of the config file changes.
+func watchConfig(ctx context.Context, path string, interval time.Duration, reload chan<- struct{}) {
+	var modTime time.Time
+	if fi, err := os.Stat(path); err == nil {
+		modTime = fi.ModTime()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			fmt.Printf("Unable to stat config file [%s]: %v\n", path, err)
+			continue
+		}
+		if !fi.ModTime().Equal(modTime) {
+			modTime = fi.ModTime()
+			select {
+			case reload <- struct{}{}:
+			default: // reload already pending
+			}
+		}
+	}
+}
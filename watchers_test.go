@@ -0,0 +1,112 @@
+// watchers_test.go
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/jshaughn/outlier/scrape"
+)
+
+// awaitQueries returns the next n queries, sorted
+func awaitQueries(t *testing.T, queried <-chan string, n int) []string {
+	var result []string
+	for len(result) < n {
+		select {
+		case q := <-queried:
+			result = append(result, q)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected [%d] queries, got %v", n, result)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// only TS of removed, or incompatibly changed, expressions are untracked
+func TestWatchersApply(t *testing.T) {
+	defer resetTracked()
+	queried := make(chan string, 100)
+	api := fakeAPI{query: func(query string, ts time.Time) (model.Value, error) {
+		queried <- query
+		return model.Matrix{}, nil
+	}}
+	w := newWatchers(context.Background(), defaultOptions(), api, scrape.Scrape{})
+	defer w.stopAll()
+
+	x, y := testExpression(t, "x"), testExpression(t, "y")
+	w.apply([]TSExpression{x, y})
+	queries := awaitQueries(t, queried, 2)
+	assertEqual(t, true, strings.HasPrefix(queries[0], "x ["))
+	assertEqual(t, true, strings.HasPrefix(queries[1], "y ["))
+	m := model.Metric{"a": "1"}
+	xSer := testSeries(x, m, time.Now())
+	ySer := testSeries(y, m, time.Now())
+	xWatcher := w.running["x"]
+
+	// restarted, keeping its TS
+	y.Interval = time.Minute
+	w.apply([]TSExpression{x, y})
+	assertEqual(t, true, strings.HasPrefix(awaitQueries(t, queried, 1)[0], "y ["))
+	assertEqual(t, xWatcher, w.running["x"])
+	assertEqual(t, time.Minute, w.running["y"].ts.Interval)
+	assertEqual(t, true, tracked(seriesKey("y", m), ySer))
+
+	// restarted, discarding its TS
+	y.SampleSize = 10
+	w.apply([]TSExpression{x, y})
+	assertEqual(t, true, strings.HasPrefix(awaitQueries(t, queried, 1)[0], "y ["))
+	assertEqual(t, xWatcher, w.running["x"])
+	assertEqual(t, false, tracked(seriesKey("y", m), ySer))
+
+	w.apply([]TSExpression{x})
+	assertEqual(t, 1, len(w.running))
+	assertEqual(t, xWatcher, w.running["x"])
+	assertEqual(t, true, tracked(seriesKey("x", m), xSer))
+
+	w.apply(nil)
+	assertEqual(t, 0, len(w.running))
+	assertEqual(t, false, tracked(seriesKey("x", m), xSer))
+}
+
+func TestStateCompatible(t *testing.T) {
+	ts := testExpression(t, "x")
+	assertEqual(t, true, ts.equals(testExpression(t, "x")))
+	assertEqual(t, true, ts.stateCompatible(ts))
+
+	for _, change := range []func(ts *TSExpression){
+		func(ts *TSExpression) { ts.Interval = time.Minute },
+		func(ts *TSExpression) {
+			offset := time.Minute
+			ts.Offset = &offset
+		},
+		func(ts *TSExpression) { ts.Timeout = time.Minute },
+		func(ts *TSExpression) { ts.Labels = map[string]string{"a": "1"} },
+	} {
+		changed := ts
+		change(&changed)
+		assertEqual(t, false, changed.equals(ts))
+		assertEqual(t, true, changed.stateCompatible(ts))
+	}
+
+	for _, change := range []func(ts *TSExpression){
+		func(ts *TSExpression) { ts.Expr = "y" },
+		func(ts *TSExpression) { ts.SampleSize = 10 },
+		func(ts *TSExpression) { ts.Rules = "westgard" },
+		func(ts *TSExpression) {
+			alertFor := time.Minute
+			ts.For = &alertFor
+		},
+		func(ts *TSExpression) { ts.ResolveAfter = 2 },
+	} {
+		changed := ts
+		change(&changed)
+		assertEqual(t, false, changed.equals(ts))
+		assertEqual(t, false, changed.stateCompatible(ts))
+	}
+}